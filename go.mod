@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/image v0.18.0
 )
 
 require github.com/corpix/uarand v0.2.0 // indirect
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+)
+
+// 别名最多展开的层数，防止配置成环
+const maxAliasDepth = 8
+
+func modelAliases() map[string]string {
+	if Cfg == nil {
+		return nil
+	}
+	return Cfg.Models.Aliases
+}
+
+func virtualModels() map[string]VirtualModel {
+	if Cfg == nil {
+		return nil
+	}
+	return Cfg.Models.VirtualModels
+}
+
+// lookupConfigKey 按名称查找配置项，先精确匹配再忽略大小写
+func lookupConfigKey[T any](items map[string]T, name string) (string, T, bool) {
+	if v, ok := items[name]; ok {
+		return name, v, true
+	}
+	for key, v := range items {
+		if strings.EqualFold(key, name) {
+			return key, v, true
+		}
+	}
+	var zero T
+	return "", zero, false
+}
+
+// ResolveModelAlias 展开模型别名，别名可带 -thinking / -search 标签
+// 例如 gpt-4o -> GLM-4.7，gpt-4o-thinking -> GLM-4.7-thinking
+func ResolveModelAlias(model string) string {
+	aliases := modelAliases()
+	for depth := 0; depth < maxAliasDepth; depth++ {
+		if _, target, ok := lookupConfigKey(aliases, model); ok {
+			model = target
+			continue
+		}
+
+		baseModel, enableThinking, enableSearch := ParseModelName(model)
+		if baseModel == model {
+			return model
+		}
+		_, target, ok := lookupConfigKey(aliases, baseModel)
+		if !ok {
+			return model
+		}
+		targetBase, targetThinking, targetSearch := ParseModelName(target)
+		model = FormatModelName(targetBase, enableThinking || targetThinking, enableSearch || targetSearch)
+	}
+	LogWarn("Model alias %s exceeds max depth, check for cycles", model)
+	return model
+}
+
+// lookupVirtualModel 查找虚拟模型，支持追加 -thinking / -search 标签
+func lookupVirtualModel(model string) (string, VirtualModel, bool) {
+	vms := virtualModels()
+	if len(vms) == 0 {
+		return "", VirtualModel{}, false
+	}
+	if name, vm, ok := lookupConfigKey(vms, model); ok {
+		return name, vm, true
+	}
+
+	baseModel, enableThinking, enableSearch := ParseModelName(model)
+	name, vm, ok := lookupConfigKey(vms, baseModel)
+	if !ok {
+		return "", VirtualModel{}, false
+	}
+	vm.Thinking = vm.Thinking || enableThinking
+	vm.Search = vm.Search || enableSearch
+	return name, vm, true
+}
+
+// virtualModelTarget 返回虚拟模型对应的真实模型名（含标签）
+func virtualModelTarget(vm VirtualModel) string {
+	baseModel, enableThinking, enableSearch := ParseModelName(ResolveModelAlias(vm.Model))
+	return FormatModelName(baseModel, enableThinking || vm.Thinking, enableSearch || vm.Search)
+}
+
+// resolveModelName 依次展开虚拟模型和别名，得到真实模型名
+func resolveModelName(model string) string {
+	if _, vm, ok := lookupVirtualModel(model); ok {
+		return virtualModelTarget(vm)
+	}
+	return ResolveModelAlias(model)
+}
+
+// ApplyModelAliases 将请求中的别名/虚拟模型替换为真实模型，并补齐虚拟模型的默认参数
+func ApplyModelAliases(req *ChatRequest) {
+	if name, vm, ok := lookupVirtualModel(req.Model); ok {
+		target := virtualModelTarget(vm)
+		LogDebug("[Alias] virtual model %s -> %s", name, target)
+		req.Model = target
+		req.sendParams = true
+
+		if vm.SystemPrompt != "" && !hasSystemMessage(req.Messages) {
+			req.Messages = append([]Message{{Role: "system", Content: vm.SystemPrompt}}, req.Messages...)
+		}
+		if req.Temperature == nil {
+			req.Temperature = vm.Temperature
+		}
+		if req.TopP == nil {
+			req.TopP = vm.TopP
+		}
+		if req.MaxTokens == nil {
+			req.MaxTokens = vm.MaxTokens
+		}
+		return
+	}
+
+	if resolved := ResolveModelAlias(req.Model); resolved != req.Model {
+		LogDebug("[Alias] %s -> %s", req.Model, resolved)
+		req.Model = resolved
+	}
+}
+
+func hasSystemMessage(messages []Message) bool {
+	for _, msg := range messages {
+		role := strings.ToLower(msg.Role)
+		if role == "system" || role == "developer" {
+			return true
+		}
+	}
+	return false
+}
+
+// listVirtualModelNames 返回虚拟模型名（排序后）
+func listVirtualModelNames() []string {
+	var names []string
+	for name := range virtualModels() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,58 @@
+package internal
+
+import "testing"
+
+func TestApplyModelAliases(t *testing.T) {
+	originCfg := Cfg
+	temperature := 0.2
+	Cfg = &Config{Models: ModelConfig{
+		Aliases: map[string]string{
+			"gpt-4o":        "GLM-4.7",
+			"claude-sonnet": "GLM-5-thinking",
+		},
+		VirtualModels: map[string]VirtualModel{
+			"coder": {Model: "gpt-4o", Thinking: true, SystemPrompt: "You are a coder.", Temperature: &temperature},
+		},
+	}}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{model: "gpt-4o", want: "GLM-4.7"},
+		{model: "GPT-4o-search", want: "GLM-4.7-search"},
+		{model: "claude-sonnet-search", want: "GLM-5-thinking-search"},
+		{model: "GLM-4.6", want: "GLM-4.6"},
+	}
+	for _, tt := range tests {
+		if got := ResolveModelAlias(tt.model); got != tt.want {
+			t.Fatalf("ResolveModelAlias(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+
+	req := ChatRequest{Model: "coder", Messages: []Message{{Role: "user", Content: "hi"}}}
+	ApplyModelAliases(&req)
+	if req.Model != "GLM-4.7-thinking" {
+		t.Fatalf("virtual model resolved to %q, want GLM-4.7-thinking", req.Model)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" {
+		t.Fatalf("virtual model system prompt not prepended: %+v", req.Messages)
+	}
+	if req.Temperature == nil || *req.Temperature != temperature {
+		t.Fatalf("virtual model temperature not applied: %v", req.Temperature)
+	}
+	if params := upstreamParams(req); params["temperature"] != temperature {
+		t.Fatalf("virtual model params = %v", params)
+	}
+	plain := ChatRequest{Model: "GLM-4.7", Temperature: &temperature}
+	ApplyModelAliases(&plain)
+	if params := upstreamParams(plain); len(params) != 0 {
+		t.Fatalf("non-virtual request params = %v, want empty", params)
+	}
+	if got := GetTargetModel("coder"); got != "glm-4.7" {
+		t.Fatalf("GetTargetModel(coder) = %q, want glm-4.7", got)
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	CapabilityPolicyReject  = "reject"
+	CapabilityPolicyReroute = "reroute"
+)
+
+// CapabilityError 请求内容超出模型能力，返回 400
+type CapabilityError struct {
+	Model  string
+	Reason string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("model %s %s", e.Model, e.Reason)
+}
+
+func capabilityPolicy() string {
+	if Cfg == nil || Cfg.CapabilityPolicy == "" {
+		return CapabilityPolicyReject
+	}
+	return Cfg.CapabilityPolicy
+}
+
+// ResolveRequestModel 按模型能力校验请求，返回实际使用的模型名（含标签）
+// 图片、文档、工具、上下文长度不满足时拒绝（或按策略改路由）；thinking/search 不支持时直接去掉标签
+func ResolveRequestModel(model string, messages []Message, tools []ToolDefinition) (string, error) {
+	baseModel, enableThinking, enableSearch := ParseModelName(model)
+	spec, ok := LookupModel(baseModel)
+	if !ok {
+		// 未知模型直接透传给上游
+		return model, nil
+	}
+
+	if len(extractAllImageURLs(messages)) > 0 && !spec.Capabilities.Vision {
+		vision, found := findVisionModel(enableThinking)
+		if !found {
+			return "", &CapabilityError{Model: model, Reason: "does not support image input and no vision model is available"}
+		}
+		if capabilityPolicy() != CapabilityPolicyReroute {
+			return "", &CapabilityError{
+				Model:  model,
+				Reason: fmt.Sprintf("does not support image input; use a vision model such as %s", vision.Name),
+			}
+		}
+		LogInfo("[Capability] %s does not support image input, rerouting to %s", model, vision.Name)
+		spec = vision
+	}
+
+	caps := spec.Capabilities
+	if len(extractAllVideoURLs(messages)) > 0 && !caps.Video {
+		reason := "does not support video input"
+		if video, found := findVideoModel(); found {
+			reason += fmt.Sprintf("; use a video model such as %s", video.Name)
+		}
+		return "", &CapabilityError{Model: model, Reason: reason}
+	}
+	if len(extractAllFileInputs(messages)) > 0 && !caps.Documents {
+		return "", &CapabilityError{Model: model, Reason: "does not support document input"}
+	}
+	if len(tools) > 0 && !caps.Tools {
+		return "", &CapabilityError{Model: model, Reason: "does not support tool calling"}
+	}
+	if caps.ContextLength > 0 {
+		if estimated := estimatePromptTokens(messages); estimated > caps.ContextLength {
+			return "", &CapabilityError{
+				Model:  model,
+				Reason: fmt.Sprintf("context length is %d tokens, but the messages need at least %d", caps.ContextLength, estimated),
+			}
+		}
+	}
+
+	if enableThinking && !caps.Thinking {
+		LogInfo("[Capability] %s does not support thinking, tag ignored", spec.Name)
+		enableThinking = false
+	}
+	if enableSearch && !caps.Search {
+		LogInfo("[Capability] %s does not support web search, tag ignored", spec.Name)
+		enableSearch = false
+	}
+
+	return FormatModelName(spec.Name, enableThinking, enableSearch), nil
+}
+
+// ResolveSearchModel 校验强制联网搜索的模型：不支持搜索时拒绝（或按策略改路由到支持搜索的模型）
+func ResolveSearchModel(model string) (string, error) {
+	baseModel, enableThinking, enableSearch := ParseModelName(model)
+	spec, ok := LookupModel(baseModel)
+	if !ok || spec.Capabilities.Search {
+		return model, nil
+	}
+
+	search, found := findSearchModel(enableThinking)
+	if !found {
+		return "", &CapabilityError{Model: model, Reason: "does not support web search and no search model is available"}
+	}
+	if capabilityPolicy() != CapabilityPolicyReroute {
+		return "", &CapabilityError{
+			Model:  model,
+			Reason: fmt.Sprintf("does not support web search; use a search model such as %s", search.Name),
+		}
+	}
+	LogInfo("[Capability] %s does not support web search, rerouting to %s", model, search.Name)
+	return FormatModelName(search.Name, enableThinking && search.Capabilities.Thinking, enableSearch), nil
+}
+
+// findSearchModel 选择一个可见的支持搜索的模型，需要 thinking 时优先支持 thinking 的
+func findSearchModel(needThinking bool) (ModelSpec, bool) {
+	var candidate ModelSpec
+	found := false
+	for _, m := range currentModels() {
+		if m.Hidden || !m.Capabilities.Search {
+			continue
+		}
+		if !found || (needThinking && m.Capabilities.Thinking && !candidate.Capabilities.Thinking) {
+			candidate = m
+			found = true
+		}
+	}
+	return candidate, found
+}
+
+// findVisionModel 选择一个可见的视觉模型，需要 thinking 时优先支持 thinking 的
+func findVisionModel(needThinking bool) (ModelSpec, bool) {
+	var candidate ModelSpec
+	found := false
+	for _, m := range currentModels() {
+		if m.Hidden || !m.Capabilities.Vision {
+			continue
+		}
+		if !found || (needThinking && m.Capabilities.Thinking && !candidate.Capabilities.Thinking) {
+			candidate = m
+			found = true
+		}
+	}
+	return candidate, found
+}
+
+// findVideoModel 选择一个可见的支持视频输入的模型，用于错误提示
+func findVideoModel() (ModelSpec, bool) {
+	for _, m := range currentModels() {
+		if !m.Hidden && m.Capabilities.Video {
+			return m, true
+		}
+	}
+	return ModelSpec{}, false
+}
+
+// estimatePromptTokens 保守估算，只用于拦截明显超长的请求
+func estimatePromptTokens(messages []Message) int {
+	tokens := 0
+	for _, msg := range messages {
+		text, _ := msg.ParseContent()
+		tokens += estimateTokens(text)
+	}
+	return tokens
+}
+
+// estimateTokens ASCII 约 4 字符 1 token，中日韩等非 ASCII 字符按 1 字符 1 token
+func estimateTokens(text string) int {
+	var e tokenEstimate
+	e.add(text)
+	return e.tokens()
+}
+
+// tokenEstimate 分段累计的 token 估算，与 estimateTokens 整段估算的结果一致
+type tokenEstimate struct {
+	ascii, other int
+}
+
+func (e *tokenEstimate) add(text string) {
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			e.ascii++
+		} else {
+			e.other++
+		}
+	}
+}
+
+func (e tokenEstimate) tokens() int {
+	return (e.ascii+3)/4 + e.other
+}
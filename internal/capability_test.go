@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveRequestModelCapabilities(t *testing.T) {
+	imageMessages := []Message{{
+		Role: "user",
+		Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "what is this"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+		},
+	}}
+
+	_, err := ResolveRequestModel("GLM-4.7", imageMessages, nil)
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) {
+		t.Fatalf("ResolveRequestModel(GLM-4.7 with image) error = %v, want CapabilityError", err)
+	}
+
+	videoMessages := []Message{{
+		Role: "user",
+		Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "describe the clip"},
+			map[string]interface{}{"type": "video_url", "video_url": map[string]interface{}{"url": "https://example.com/a.mp4"}},
+		},
+	}}
+	if _, err := ResolveRequestModel("GLM-4.7", videoMessages, nil); !errors.As(err, &capErr) || !strings.Contains(err.Error(), "video") {
+		t.Fatalf("ResolveRequestModel(GLM-4.7 with video) error = %v, want video CapabilityError", err)
+	}
+	if got, err := ResolveRequestModel("GLM-4.6-V", videoMessages, nil); err != nil || got != "GLM-4.6-V" {
+		t.Fatalf("ResolveRequestModel(GLM-4.6-V with video) = (%q, %v)", got, err)
+	}
+
+	originCfg := Cfg
+	Cfg = &Config{CapabilityPolicy: CapabilityPolicyReroute}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	got, err := ResolveRequestModel("GLM-4.7-thinking", imageMessages, nil)
+	if err != nil || got != "GLM-4.6-V-thinking" {
+		t.Fatalf("ResolveRequestModel(reroute) = (%q, %v), want (%q, nil)", got, err, "GLM-4.6-V-thinking")
+	}
+
+	got, err = ResolveRequestModel("GLM-4.6-V-search", []Message{{Role: "user", Content: "hi"}}, nil)
+	if err != nil || got != "GLM-4.6-V" {
+		t.Fatalf("ResolveRequestModel(GLM-4.6-V-search) = (%q, %v), want (%q, nil)", got, err, "GLM-4.6-V")
+	}
+}
+
+func TestEstimatePromptTokensCountsCJK(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: strings.Repeat("a", 400)},
+		{Role: "user", Content: strings.Repeat("中", 400)},
+	}
+	if got := estimatePromptTokens(messages); got != 500 {
+		t.Fatalf("estimatePromptTokens() = %d, want 500", got)
+	}
+}
+
+func TestResolveSearchModel(t *testing.T) {
+	originCfg := Cfg
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	Cfg = &Config{}
+	if got, err := ResolveSearchModel("GLM-4.7"); err != nil || got != "GLM-4.7" {
+		t.Fatalf("ResolveSearchModel(GLM-4.7) = (%q, %v)", got, err)
+	}
+	var capErr *CapabilityError
+	if _, err := ResolveSearchModel("GLM-4.6"); !errors.As(err, &capErr) || !strings.Contains(err.Error(), "web search") {
+		t.Fatalf("ResolveSearchModel(GLM-4.6) error = %v, want web search CapabilityError", err)
+	}
+
+	Cfg = &Config{CapabilityPolicy: CapabilityPolicyReroute}
+	got, err := ResolveSearchModel("GLM-4.6")
+	if err != nil {
+		t.Fatalf("ResolveSearchModel(GLM-4.6) with reroute: %v", err)
+	}
+	if spec, ok := LookupModel(got); !ok || !spec.Capabilities.Search {
+		t.Fatalf("ResolveSearchModel(GLM-4.6) rerouted to %q, which does not support search", got)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/corpix/uarand"
 	"github.com/google/uuid"
@@ -34,7 +36,59 @@ func extractAllImageURLs(messages []Message) []string {
 	return allImageURLs
 }
 
-func makeUpstreamRequest(token string, messages []Message, model string, tools []ToolDefinition, toolChoice interface{}) (*http.Response, string, error) {
+func extractAllVideoURLs(messages []Message) []string {
+	var allVideoURLs []string
+	for _, msg := range messages {
+		allVideoURLs = append(allVideoURLs, msg.ParseVideoURLs()...)
+	}
+	return allVideoURLs
+}
+
+func extractAllFileInputs(messages []Message) []FileInput {
+	var allFiles []FileInput
+	for _, msg := range messages {
+		allFiles = append(allFiles, msg.ParseFileParts()...)
+	}
+	return allFiles
+}
+
+// upstreamFileEntry 构建上游请求 files 数组中的一项
+func upstreamFileEntry(f *UpstreamFile, userMsgID string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":            f.Type,
+		"file":            f.File,
+		"id":              f.ID,
+		"url":             f.URL,
+		"name":            f.Name,
+		"status":          f.Status,
+		"size":            f.Size,
+		"error":           f.Error,
+		"itemId":          f.ItemID,
+		"media":           f.Media,
+		"ref_user_msg_id": userMsgID,
+	}
+}
+
+// upstreamParams 将虚拟模型请求的采样参数转换为上游 params
+func upstreamParams(req ChatRequest) map[string]interface{} {
+	params := map[string]interface{}{}
+	if !req.sendParams {
+		return params
+	}
+	if req.Temperature != nil {
+		params["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		params["top_p"] = *req.TopP
+	}
+	if req.MaxTokens != nil {
+		params["max_tokens"] = *req.MaxTokens
+	}
+	return params
+}
+
+func makeUpstreamRequest(token string, req ChatRequest) (*http.Response, string, error) {
+
 	payload, err := DecodeJWTPayload(token)
 	if err != nil || payload == nil {
 		return nil, "", fmt.Errorf("invalid token")
@@ -46,9 +100,9 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 	requestID := uuid.New().String()
 	userMsgID := uuid.New().String()
 
-	targetModel := GetTargetModel(model)
-	latestUserContent := extractLatestUserContent(messages)
-	imageURLs := extractAllImageURLs(messages)
+	targetModel := GetTargetModel(req.Model)
+	latestUserContent := extractLatestUserContent(req.Messages)
+	imageURLs := extractAllImageURLs(req.Messages)
 
 	signature := GenerateSignature(userID, requestID, latestUserContent, timestamp)
 
@@ -58,15 +112,16 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 		fmt.Sprintf("/c/%s", chatID),
 		timestamp)
 
-	enableThinking := IsThinkingModel(model)
-	autoWebSearch := IsSearchModel(model)
-	if targetModel == "glm-4.5v" || targetModel == "glm-4.6v" {
-		autoWebSearch = false
-	}
+	baseModel, enableThinking, searchTagged := ParseModelName(req.Model)
+	webSearch, autoWebSearch := searchFeatures(resolveSearchMode(req, searchTagged))
 
-	var mcpServers []string
-	if targetModel == "glm-4.6v" {
-		mcpServers = []string{"vlm-image-search", "vlm-image-recognition", "vlm-image-processing"}
+	mcpServers := req.MCPServers
+	if spec, ok := LookupModel(baseModel); ok {
+		if (webSearch || autoWebSearch) && !spec.Capabilities.Search {
+			LogInfo("[Capability] %s does not support web search, search ignored", spec.Name)
+			webSearch, autoWebSearch = false, false
+		}
+		mcpServers = resolveMCPServers(spec, req.MCPServers)
 	}
 
 	urlToFileID := make(map[string]string)
@@ -80,23 +135,31 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 			if f.SourceURL != "" {
 				urlToFileID[f.SourceURL] = f.ID
 			}
-			filesData = append(filesData, map[string]interface{}{
-				"type":            f.Type,
-				"file":            f.File,
-				"id":              f.ID,
-				"url":             f.URL,
-				"name":            f.Name,
-				"status":          f.Status,
-				"size":            f.Size,
-				"error":           f.Error,
-				"itemId":          f.ItemID,
-				"media":           f.Media,
-				"ref_user_msg_id": userMsgID,
-			})
-		}
-	}
-
-	preprocessedMessages := preprocessMessagesForTools(messages, tools, toolChoice)
+			filesData = append(filesData, upstreamFileEntry(f, userMsgID))
+		}
+	}
+
+	if videoURLs := extractAllVideoURLs(req.Messages); len(videoURLs) > 0 {
+		files, err := UploadVideos(token, videoURLs)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, f := range files {
+			filesData = append(filesData, upstreamFileEntry(f, userMsgID))
+		}
+	}
+
+	if fileInputs := extractAllFileInputs(req.Messages); len(fileInputs) > 0 {
+		files, err := UploadDocuments(token, fileInputs)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, f := range files {
+			filesData = append(filesData, upstreamFileEntry(f, userMsgID))
+		}
+	}
+
+	preprocessedMessages := preprocessMessagesForTools(req.Messages, req.Tools, req.ToolChoice)
 	var upstreamMessages []map[string]interface{}
 	for _, msg := range preprocessedMessages {
 		upstreamMessages = append(upstreamMessages, msg.ToUpstreamMessage(urlToFileID))
@@ -107,10 +170,10 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 		"model":            targetModel,
 		"messages":         upstreamMessages,
 		"signature_prompt": latestUserContent,
-		"params":           map[string]interface{}{},
+		"params":           upstreamParams(req),
 		"features": map[string]interface{}{
-			"image_generation": false,
-			"web_search":       false,
+			"image_generation": req.ImageGeneration,
+			"web_search":       webSearch,
 			"auto_web_search":  autoWebSearch,
 			"preview_mode":     true,
 			"enable_thinking":  enableThinking,
@@ -119,12 +182,13 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 		"id":      uuid.New().String(),
 	}
 
-	if len(mcpServers) > 0 {
+	// 显式的空列表也发送，关闭上游默认开启的服务
+	if mcpServers != nil {
 		body["mcp_servers"] = mcpServers
 	}
 
-	if len(tools) > 0 {
-		body["tools"] = tools
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
 	}
 
 	if len(filesData) > 0 {
@@ -134,24 +198,24 @@ func makeUpstreamRequest(token string, messages []Message, model string, tools [
 
 	bodyBytes, _ := json.Marshal(body)
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(bodyBytes))
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("X-FE-Version", GetFeVersion())
-	req.Header.Set("X-Signature", signature)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Origin", "https://chat.z.ai")
-	req.Header.Set("Referer", fmt.Sprintf("https://chat.z.ai/c/%s", uuid.New().String()))
-	req.Header.Set("User-Agent", uarand.GetRandom())
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("X-FE-Version", GetFeVersion())
+	httpReq.Header.Set("X-Signature", signature)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Connection", "keep-alive")
+	httpReq.Header.Set("Origin", "https://chat.z.ai")
+	httpReq.Header.Set("Referer", fmt.Sprintf("https://chat.z.ai/c/%s", uuid.New().String()))
+	httpReq.Header.Set("User-Agent", uarand.GetRandom())
 
 	client := GetStickyProxyClient(token)
-	resp, err := client.Do(req)
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return nil, "", err
+		return nil, "", &UpstreamTransportError{Err: err}
 	}
 
 	return resp, targetModel, nil
@@ -190,6 +254,74 @@ type ThinkingFilter struct {
 	lastOutputChunk      string
 	lastPhase            string
 	thinkingRoundCount   int
+
+	// 思考预算，字符和 token 跨多轮思考累计，时长只计思考阶段
+	budget          reasoningBudget
+	budgetBody      io.Closer
+	budgetTimer     *time.Timer
+	budgetStarted   time.Time     // 本轮思考开始计时的时间，未计时为零值
+	budgetElapsed   time.Duration // 之前各轮思考已用的时长
+	budgetExceeded  atomic.Bool
+	reasoning       strings.Builder // 已输出的思考内容
+	reasoningChars  int
+	reasoningTokens tokenEstimate
+}
+
+// StartBudget 开始按预算计时，超时后关闭上游响应使读取中止
+func (f *ThinkingFilter) StartBudget(budget reasoningBudget, body io.Closer) {
+	f.budget = budget
+	f.budgetBody = body
+	f.ResumeBudget()
+}
+
+// ResumeBudget 进入思考阶段后继续计时
+func (f *ThinkingFilter) ResumeBudget() {
+	if f.budget.Timeout <= 0 || !f.budgetStarted.IsZero() {
+		return
+	}
+	remaining := f.budget.Timeout - f.budgetElapsed
+	if remaining <= 0 {
+		remaining = time.Nanosecond
+	}
+	body := f.budgetBody
+	f.budgetStarted = time.Now()
+	f.budgetTimer = time.AfterFunc(remaining, func() {
+		f.budgetExceeded.Store(true)
+		body.Close()
+	})
+}
+
+// PauseBudget 离开思考阶段（回答、工具调用等）后暂停计时，之后再次思考时继续
+func (f *ThinkingFilter) PauseBudget() {
+	if f.budgetStarted.IsZero() {
+		return
+	}
+	f.budgetTimer.Stop()
+	f.budgetElapsed += time.Since(f.budgetStarted)
+	f.budgetStarted = time.Time{}
+}
+
+// StopBudget 响应结束后不再限制
+func (f *ThinkingFilter) StopBudget() {
+	f.PauseBudget()
+	f.budget = reasoningBudget{}
+}
+
+// TrackReasoning 记录已输出的思考内容，超出字符或 token 预算时返回 true
+func (f *ThinkingFilter) TrackReasoning(text string) bool {
+	f.reasoning.WriteString(text)
+	f.reasoningChars += utf8.RuneCountInString(text)
+	f.reasoningTokens.add(text)
+	if (f.budget.MaxChars > 0 && f.reasoningChars > f.budget.MaxChars) ||
+		(f.budget.MaxTokens > 0 && f.reasoningTokens.tokens() > f.budget.MaxTokens) {
+		f.budgetExceeded.Store(true)
+	}
+	return f.budgetExceeded.Load()
+}
+
+// BudgetExceeded 是否因超出预算中止
+func (f *ThinkingFilter) BudgetExceeded() bool {
+	return f.budgetExceeded.Load()
 }
 
 func (f *ThinkingFilter) ProcessThinking(deltaContent string) string {
@@ -266,11 +398,13 @@ func (f *ThinkingFilter) ResetForNewRound() {
 	f.hasSeenFirstThinking = false
 }
 
-func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
+// requestToken 从 Authorization 头读取 z.ai token，free 换成匿名 token
+// 失败时已写入错误响应
+func requestToken(w http.ResponseWriter, r *http.Request) (string, bool) {
 	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 	if token == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return "", false
 	}
 
 	if token == "free" {
@@ -278,55 +412,175 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			LogError("Failed to get anonymous token: %v", err)
 			http.Error(w, "Failed to get anonymous token", http.StatusInternalServerError)
-			return
+			return "", false
 		}
 		token = anonymousToken
 	}
 
+	return token, true
+}
+
+func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	token, ok := requestToken(w, r)
+	if !ok {
+		return
+	}
+
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	if !ValidCitationFormat(req.CitationFormat) {
+		http.Error(w, fmt.Sprintf("Invalid citation_format %q", req.CitationFormat), http.StatusBadRequest)
+		return
+	}
+	if !ValidSearchMode(req.Search) {
+		http.Error(w, fmt.Sprintf("Invalid search %q, must be auto, force or off", req.Search), http.StatusBadRequest)
+		return
+	}
+	if !ValidReasoningFormat(req.ReasoningFormat) {
+		http.Error(w, fmt.Sprintf("Invalid reasoning_format %q", req.ReasoningFormat), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateReasoningParams(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if req.Model == "" {
 		req.Model = "GLM-4.6"
 	}
 
-	resp, modelName, err := makeUpstreamRequest(token, req.Messages, req.Model, req.Tools, req.ToolChoice)
+	ApplyModelAliases(&req)
+	ApplyReasoningParams(&req)
+
+	resolvedModel, err := ResolveRequestModel(req.Model, req.Messages, req.Tools)
+	if err != nil {
+		LogWarn("Rejected request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Model = resolvedModel
+	if err := ValidateMCPServers(req.Model, req.MCPServers); err != nil {
+		LogWarn("Rejected request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, modelName, servedModel, err := requestWithFallback(token, req)
 	if err != nil {
 		LogError("Upstream request failed: %v", err)
 		if errors.Is(err, ErrImageUploadUnauthorized) {
 			http.Error(w, "Image upload unauthorized for current token. Please use a token with file-upload permission.", http.StatusUnauthorized)
 			return
 		}
+		var uploadErr *ImageUploadError
+		if errors.As(err, &uploadErr) {
+			http.Error(w, err.Error(), uploadErr.StatusCode())
+			return
+		}
+		var docErr *DocumentUploadError
+		var videoErr *VideoUploadError
+		if errors.As(err, &docErr) || errors.As(err, &videoErr) {
+			status := http.StatusBadRequest
+			if isUpstreamUploadFailure(err) {
+				status = http.StatusBadGateway
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		var statusErr *UpstreamStatusError
+		if errors.As(err, &statusErr) {
+			http.Error(w, "Upstream error", statusErr.StatusCode)
+			return
+		}
 		http.Error(w, "Upstream error", http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		bodyStr := string(body)
-		if len(bodyStr) > 500 {
-			bodyStr = bodyStr[:500]
+	if servedModel != req.Model {
+		w.Header().Set(FallbackHeader, servedModel)
+	}
+
+	completionID := fmt.Sprintf("chatcmpl-%s", uuid.New().String()[:29])
+	opts := responseOptions{
+		hasFunctionCalling:  len(req.Tools) > 0,
+		citationFormat:      resolveCitationFormat(req.CitationFormat),
+		imageSearchMarkdown: req.ImageSearchMarkdown,
+		toolTrace:           req.ToolTrace,
+		reasoningFormat:     resolveReasoningFormat(req.ReasoningFormat),
+	}
+	req.Model = servedModel
+	opts.budget = requestReasoningBudget(req)
+
+	respond := func(body io.ReadCloser, opts responseOptions) *reasoningAbort {
+		if req.Stream {
+			return handleStreamResponse(w, body, completionID, modelName, opts)
 		}
-		LogError("Upstream error: status=%d, body=%s", resp.StatusCode, bodyStr)
-		http.Error(w, "Upstream error", resp.StatusCode)
+		return handleNonStreamResponse(w, body, completionID, modelName, opts)
+	}
+
+	abort := respond(resp.Body, opts)
+	if abort == nil {
 		return
 	}
 
-	completionID := fmt.Sprintf("chatcmpl-%s", uuid.New().String()[:29])
-	hasFunctionCalling := len(req.Tools) > 0
+	// 超出思考预算：关闭思考重新请求，流式输出接在已输出的内容之后
+	retryReq := reasoningRetryRequest(req, abort.Reasoning)
+	LogWarn("[Reasoning] %s exceeded reasoning budget after %d chars, retrying with %s",
+		req.Model, utf8.RuneCountInString(abort.Reasoning), retryReq.Model)
+	retryResp, _, _, err := requestWithFallback(token, retryReq)
+	if err != nil {
+		LogError("Reasoning budget retry failed: %v", err)
+		if req.Stream {
+			// 已输出的内容无法撤回，补一个结束块让客户端正常收尾
+			writeStreamFinish(w, completionID, modelName, "stop")
+			return
+		}
+		http.Error(w, "Upstream error", http.StatusBadGateway)
+		return
+	}
+	defer retryResp.Body.Close()
 
-	if req.Stream {
-		handleStreamResponse(w, resp.Body, completionID, modelName, hasFunctionCalling)
-	} else {
-		handleNonStreamResponse(w, resp.Body, completionID, modelName, hasFunctionCalling)
+	opts.budget = reasoningBudget{}
+	opts.contentOffset = abort.ContentRunes
+	opts.priorReasoning = abort.Reasoning
+	respond(retryResp.Body, opts)
+}
+
+// responseOptions 请求级的响应输出选项
+type responseOptions struct {
+	hasFunctionCalling  bool
+	citationFormat      string
+	imageSearchMarkdown bool
+	toolTrace           bool
+	reasoningFormat     string
+
+	// 思考预算，以及超出预算重新请求时衔接上一次的输出
+	budget         reasoningBudget
+	contentOffset  int    // 流式：已输出的 content 字符数
+	priorReasoning string // 非流式：上一次的思考内容
+}
+
+// newToolTrace 开启 tool_trace 时创建调用记录，否则返回 nil
+func (o responseOptions) newToolTrace() *ToolTrace {
+	if !o.toolTrace {
+		return nil
 	}
+	return NewToolTrace()
 }
 
-func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, hasFunctionCalling bool) {
+// newSearchRefFilter 按请求的引用格式创建过滤器
+func (o responseOptions) newSearchRefFilter() *SearchRefFilter {
+	f := NewSearchRefFilter()
+	f.SetCitationFormat(resolveCitationFormat(o.citationFormat))
+	return f
+}
+
+// handleStreamResponse 转换上游流为 OpenAI 流式响应；超出思考预算时中止，不输出结束标记，返回 reasoningAbort
+func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, opts responseOptions) *reasoningAbort {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -335,20 +589,102 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-		return
+		return nil
 	}
 
 	scanner := bufio.NewScanner(body)
 	scanner.Buffer(make([]byte, 1024*1024), 8*1024*1024)
+	hasFunctionCalling := opts.hasFunctionCalling
 	hasContent := false
-	searchRefFilter := NewSearchRefFilter()
+	searchRefFilter := opts.newSearchRefFilter()
 	thinkingFilter := &ThinkingFilter{}
+	thinkingFilter.StartBudget(opts.budget, body)
+	defer thinkingFilter.StopBudget()
 	pendingSourcesMarkdown := ""
 	pendingImageSearchMarkdown := ""
 	totalContentOutputLength := 0 // 记录已输出的 content 字符长度
 	collectedToolCalls := make([]ToolCall, 0)
 	answerText := ""
 	emittedAnswerChars := 0
+	generatedImages := make(map[string]bool)
+	toolTrace := opts.newToolTrace()
+	emittedContentRunes := opts.contentOffset // 已输出的 content 字符数，用于换算注解偏移
+	var answerAnnotations []Annotation        // 偏移相对 answerText，随对应文本一起输出
+
+	reasoningFormat := resolveReasoningFormat(opts.reasoningFormat)
+	thinkOpen := false // think 格式下已输出 <think> 尚未闭合
+
+	writeDelta := func(delta Delta) {
+		hasContent = true
+		emittedContentRunes += utf8.RuneCountInString(delta.Content)
+		chunk := ChatCompletionChunk{
+			ID:      completionID,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   modelName,
+			Choices: []Choice{{
+				Index:        0,
+				Delta:        delta,
+				FinishReason: nil,
+			}},
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	// closeThink 正文开始前闭合 <think>
+	closeThink := func() {
+		if thinkOpen {
+			thinkOpen = false
+			writeDelta(Delta{Content: thinkCloseTag})
+		}
+	}
+	sendDelta := func(delta Delta) {
+		if delta.Content != "" {
+			closeThink()
+		}
+		writeDelta(delta)
+	}
+	// sendReasoning 按 reasoning_format 输出思考内容
+	sendReasoning := func(text string) {
+		switch reasoningFormat {
+		case ReasoningFormatHidden:
+		case ReasoningFormatSummary:
+			writeDelta(Delta{Reasoning: newReasoningSummary(text)})
+		case ReasoningFormatThink:
+			if !thinkOpen {
+				thinkOpen = true
+				text = thinkOpenTag + text
+			}
+			writeDelta(Delta{Content: text})
+		default:
+			writeDelta(Delta{ReasoningContent: text})
+		}
+	}
+	// sendContent 输出正文，注解偏移从相对本段文本换算为相对整条回复
+	sendContent := func(content string, annotations []Annotation) {
+		closeThink()
+		annotations = shiftAnnotations(annotations, emittedContentRunes)
+		for _, a := range annotations {
+			a.URLCitation.StartIndex = max(a.URLCitation.StartIndex, 0)
+		}
+		sendDelta(Delta{Content: content, Annotations: annotations})
+	}
+	// sendAnswerSlice 输出 answerText[from:to]，并带上已完整输出的注解
+	sendAnswerSlice := func(from, to int) {
+		fromRunes := utf8.RuneCountInString(answerText[:from])
+		toRunes := fromRunes + utf8.RuneCountInString(answerText[from:to])
+		var ready, rest []Annotation
+		for _, a := range answerAnnotations {
+			if a.URLCitation.EndIndex <= toRunes {
+				ready = append(ready, a)
+			} else {
+				rest = append(rest, a)
+			}
+		}
+		answerAnnotations = rest
+		sendContent(answerText[from:to], shiftAnnotations(ready, -fromRunes))
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -376,6 +712,13 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 			break
 		}
 
+		// 思考预算只在思考阶段计时，内容为空的思考事件也算
+		if upstream.Data.Phase == "thinking" {
+			thinkingFilter.ResumeBudget()
+		} else if upstream.Data.Phase != "" {
+			thinkingFilter.PauseBudget()
+		}
+
 		if upstream.Data.Phase == "thinking" && upstream.Data.DeltaContent != "" {
 			isNewThinkingRound := false
 			if thinkingFilter.lastPhase != "" && thinkingFilter.lastPhase != "thinking" {
@@ -393,24 +736,13 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 
 			if reasoningContent != "" {
 				thinkingFilter.lastOutputChunk = reasoningContent
-				reasoningContent = searchRefFilter.Process(reasoningContent)
+				reasoningContent = searchRefFilter.ProcessReasoning(reasoningContent)
 
 				if reasoningContent != "" {
-					hasContent = true
-					chunk := ChatCompletionChunk{
-						ID:      completionID,
-						Object:  "chat.completion.chunk",
-						Created: time.Now().Unix(),
-						Model:   modelName,
-						Choices: []Choice{{
-							Index:        0,
-							Delta:        Delta{ReasoningContent: reasoningContent},
-							FinishReason: nil,
-						}},
+					sendReasoning(reasoningContent)
+					if thinkingFilter.TrackReasoning(reasoningContent) {
+						break
 					}
-					data, _ := json.Marshal(chunk)
-					fmt.Fprintf(w, "data: %s\n\n", data)
-					flusher.Flush()
 				}
 			}
 			continue
@@ -421,6 +753,11 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		}
 
 		editContent := upstream.GetEditContent()
+		if toolTrace != nil && editContent != "" {
+			if finished := toolTrace.Observe(editContent); len(finished) > 0 {
+				sendDelta(Delta{ToolTrace: finished})
+			}
+		}
 		if editContent != "" && IsSearchResultContent(editContent) {
 			if results := ParseSearchResults(editContent); len(results) > 0 {
 				searchRefFilter.AddSearchResults(results)
@@ -431,50 +768,32 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		if editContent != "" && strings.Contains(editContent, `"search_image"`) {
 			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
 			if textBeforeBlock != "" {
-				textBeforeBlock = searchRefFilter.Process(textBeforeBlock)
+				textBeforeBlock, annotations := searchRefFilter.ProcessContent(textBeforeBlock)
 				if textBeforeBlock != "" {
-					hasContent = true
-					chunk := ChatCompletionChunk{
-						ID:      completionID,
-						Object:  "chat.completion.chunk",
-						Created: time.Now().Unix(),
-						Model:   modelName,
-						Choices: []Choice{{
-							Index:        0,
-							Delta:        Delta{Content: textBeforeBlock},
-							FinishReason: nil,
-						}},
-					}
-					data, _ := json.Marshal(chunk)
-					fmt.Fprintf(w, "data: %s\n\n", data)
-					flusher.Flush()
+					sendContent(textBeforeBlock, annotations)
 				}
 			}
 			if results := ParseImageSearchResults(editContent); len(results) > 0 {
-				pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				sendDelta(Delta{ImageSearchResults: results})
+				if opts.imageSearchMarkdown {
+					pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				}
+			}
+			continue
+		}
+		if editContent != "" && IsImageGenerationPayload(editContent) {
+			imageContent := ExtractTextBeforeGlmBlock(editContent) + FormatGeneratedImages(ParseGeneratedImages(editContent), generatedImages)
+			if imageContent != "" {
+				sendDelta(Delta{Content: imageContent})
 			}
 			continue
 		}
 		if editContent != "" && IsToolCallPayload(editContent) {
 			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
 			if textBeforeBlock != "" {
-				textBeforeBlock = searchRefFilter.Process(textBeforeBlock)
+				textBeforeBlock, annotations := searchRefFilter.ProcessContent(textBeforeBlock)
 				if textBeforeBlock != "" {
-					hasContent = true
-					chunk := ChatCompletionChunk{
-						ID:      completionID,
-						Object:  "chat.completion.chunk",
-						Created: time.Now().Unix(),
-						Model:   modelName,
-						Choices: []Choice{{
-							Index:        0,
-							Delta:        Delta{Content: textBeforeBlock},
-							FinishReason: nil,
-						}},
-					}
-					data, _ := json.Marshal(chunk)
-					fmt.Fprintf(w, "data: %s\n\n", data)
-					flusher.Flush()
+					sendContent(textBeforeBlock, annotations)
 				}
 			}
 			continue
@@ -484,83 +803,27 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		}
 
 		if pendingSourcesMarkdown != "" {
-			hasContent = true
-			chunk := ChatCompletionChunk{
-				ID:      completionID,
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   modelName,
-				Choices: []Choice{{
-					Index:        0,
-					Delta:        Delta{Content: pendingSourcesMarkdown},
-					FinishReason: nil,
-				}},
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+			sendDelta(Delta{Content: pendingSourcesMarkdown})
 			pendingSourcesMarkdown = ""
 		}
 		if pendingImageSearchMarkdown != "" {
-			hasContent = true
-			chunk := ChatCompletionChunk{
-				ID:      completionID,
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   modelName,
-				Choices: []Choice{{
-					Index:        0,
-					Delta:        Delta{Content: pendingImageSearchMarkdown},
-					FinishReason: nil,
-				}},
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+			sendDelta(Delta{Content: pendingImageSearchMarkdown})
 			pendingImageSearchMarkdown = ""
 		}
 
 		content := ""
 		reasoningContent := ""
 
-		if thinkingRemaining := thinkingFilter.Flush(); thinkingRemaining != "" {
+		thinkingRemaining := thinkingFilter.Flush()
+		if thinkingRemaining != "" {
 			thinkingFilter.lastOutputChunk = thinkingRemaining
-			processedRemaining := searchRefFilter.Process(thinkingRemaining)
-			if processedRemaining != "" {
-				hasContent = true
-				chunk := ChatCompletionChunk{
-					ID:      completionID,
-					Object:  "chat.completion.chunk",
-					Created: time.Now().Unix(),
-					Model:   modelName,
-					Choices: []Choice{{
-						Index:        0,
-						Delta:        Delta{ReasoningContent: processedRemaining},
-						FinishReason: nil,
-					}},
-				}
-				data, _ := json.Marshal(chunk)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				flusher.Flush()
-			}
+		}
+		if processedRemaining := searchRefFilter.ProcessReasoning(thinkingRemaining) + searchRefFilter.FlushReasoning(); processedRemaining != "" {
+			sendReasoning(processedRemaining)
 		}
 
 		if pendingSourcesMarkdown != "" && thinkingFilter.hasSeenFirstThinking {
-			hasContent = true
-			chunk := ChatCompletionChunk{
-				ID:      completionID,
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   modelName,
-				Choices: []Choice{{
-					Index:        0,
-					Delta:        Delta{ReasoningContent: pendingSourcesMarkdown},
-					FinishReason: nil,
-				}},
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+			sendReasoning(pendingSourcesMarkdown)
 			pendingSourcesMarkdown = ""
 		}
 
@@ -593,37 +856,24 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		}
 
 		if reasoningContent != "" {
-			reasoningContent = searchRefFilter.Process(reasoningContent) + searchRefFilter.Flush()
+			reasoningContent = searchRefFilter.ProcessReasoning(reasoningContent) + searchRefFilter.FlushReasoning()
 		}
 		if reasoningContent != "" {
-			hasContent = true
-			chunk := ChatCompletionChunk{
-				ID:      completionID,
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   modelName,
-				Choices: []Choice{{
-					Index:        0,
-					Delta:        Delta{ReasoningContent: reasoningContent},
-					FinishReason: nil,
-				}},
-			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+			sendReasoning(reasoningContent)
 		}
 
 		if content == "" {
 			continue
 		}
 
-		content = searchRefFilter.Process(content)
+		content, annotations := searchRefFilter.ProcessContent(content)
 		if content == "" {
 			continue
 		}
 
 		rawContent := content
 		if hasFunctionCalling {
+			answerAnnotations = append(answerAnnotations, shiftAnnotations(annotations, utf8.RuneCountInString(answerText))...)
 			answerText += content
 			safeDelta, newEmitted, _ := DrainSafeAnswerDelta(answerText, emittedAnswerChars, true, FunctionCallTriggerSignal)
 			emittedAnswerChars = newEmitted
@@ -636,7 +886,6 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 			}
 		}
 
-		hasContent = true
 		if upstream.Data.Phase == "answer" && upstream.Data.DeltaContent != "" {
 			if hasFunctionCalling {
 				totalContentOutputLength += len([]rune(rawContent))
@@ -645,50 +894,32 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 			}
 		}
 
-		chunk := ChatCompletionChunk{
-			ID:      completionID,
-			Object:  "chat.completion.chunk",
-			Created: time.Now().Unix(),
-			Model:   modelName,
-			Choices: []Choice{{
-				Index:        0,
-				Delta:        Delta{Content: content},
-				FinishReason: nil,
-			}},
+		if hasFunctionCalling {
+			sendAnswerSlice(emittedAnswerChars-len(content), emittedAnswerChars)
+		} else {
+			sendContent(content, annotations)
 		}
-
-		data, _ := json.Marshal(chunk)
-		fmt.Fprintf(w, "data: %s\n\n", data)
-		flusher.Flush()
 	}
 
+	if thinkingFilter.BudgetExceeded() {
+		closeThink()
+		return &reasoningAbort{Reasoning: thinkingFilter.reasoning.String(), ContentRunes: emittedContentRunes}
+	}
 	if err := scanner.Err(); err != nil {
 		LogError("[Upstream] scanner error: %v", err)
 	}
 
-	if remaining := searchRefFilter.Flush(); remaining != "" {
+	if remaining, annotations := searchRefFilter.FlushContent(); remaining != "" {
 		if hasFunctionCalling {
+			answerAnnotations = append(answerAnnotations, shiftAnnotations(annotations, utf8.RuneCountInString(answerText))...)
 			answerText += remaining
 			safeRemaining, newEmitted, _ := DrainSafeAnswerDelta(answerText, emittedAnswerChars, true, FunctionCallTriggerSignal)
 			emittedAnswerChars = newEmitted
-			remaining = safeRemaining
-		}
-		if remaining != "" {
-			hasContent = true
-			chunk := ChatCompletionChunk{
-				ID:      completionID,
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   modelName,
-				Choices: []Choice{{
-					Index:        0,
-					Delta:        Delta{Content: remaining},
-					FinishReason: nil,
-				}},
+			if safeRemaining != "" {
+				sendAnswerSlice(emittedAnswerChars-len(safeRemaining), emittedAnswerChars)
 			}
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+		} else {
+			sendContent(remaining, annotations)
 		}
 	}
 
@@ -697,24 +928,7 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		if parsedToolCalls, prefixPos := ParseFunctionCallsXML(answerText); len(parsedToolCalls) > 0 {
 			hasParsedXMLToolCalls = true
 			if prefixPos > emittedAnswerChars {
-				prefixDelta := answerText[emittedAnswerChars:prefixPos]
-				if prefixDelta != "" {
-					hasContent = true
-					chunk := ChatCompletionChunk{
-						ID:      completionID,
-						Object:  "chat.completion.chunk",
-						Created: time.Now().Unix(),
-						Model:   modelName,
-						Choices: []Choice{{
-							Index:        0,
-							Delta:        Delta{Content: prefixDelta},
-							FinishReason: nil,
-						}},
-					}
-					data, _ := json.Marshal(chunk)
-					fmt.Fprintf(w, "data: %s\n\n", data)
-					flusher.Flush()
-				}
+				sendAnswerSlice(emittedAnswerChars, prefixPos)
 			}
 			collectedToolCalls = MergeToolCalls(collectedToolCalls, parsedToolCalls)
 		}
@@ -723,25 +937,24 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 			tailDelta, newEnd := DrainSafeAnswerTail(answerText, emittedAnswerChars, FunctionCallTriggerSignal)
 			emittedAnswerChars = newEnd
 			if tailDelta != "" {
-				hasContent = true
-				chunk := ChatCompletionChunk{
-					ID:      completionID,
-					Object:  "chat.completion.chunk",
-					Created: time.Now().Unix(),
-					Model:   modelName,
-					Choices: []Choice{{
-						Index:        0,
-						Delta:        Delta{Content: tailDelta},
-						FinishReason: nil,
-					}},
-				}
-				data, _ := json.Marshal(chunk)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				flusher.Flush()
+				sendAnswerSlice(emittedAnswerChars-len(tailDelta), emittedAnswerChars)
 			}
 		}
 	}
 
+	if toolTrace != nil {
+		if pending := toolTrace.Pending(); len(pending) > 0 {
+			sendDelta(Delta{ToolTrace: pending})
+		}
+	}
+
+	if len(collectedToolCalls) == 0 {
+		if footer := searchRefFilter.GetCitationFooter(); footer != "" {
+			sendDelta(Delta{Content: footer})
+		}
+	}
+	closeThink()
+
 	if len(collectedToolCalls) > 0 {
 		hasContent = true
 		for i, toolCall := range collectedToolCalls {
@@ -786,14 +999,19 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		fmt.Fprintf(w, "data: %s\n\n", data)
 		fmt.Fprintf(w, "data: [DONE]\n\n")
 		flusher.Flush()
-		return
+		return nil
 	}
 
 	if !hasContent {
 		LogError("Stream response 200 but no content received")
 	}
 
-	stopReason := "stop"
+	writeStreamFinish(w, completionID, modelName, "stop")
+	return nil
+}
+
+// writeStreamFinish 输出带 finish_reason 的结束块和 [DONE]
+func writeStreamFinish(w http.ResponseWriter, completionID, modelName, reason string) {
 	finalChunk := ChatCompletionChunk{
 		ID:      completionID,
 		Object:  "chat.completion.chunk",
@@ -802,27 +1020,36 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 		Choices: []Choice{{
 			Index:        0,
 			Delta:        Delta{},
-			FinishReason: &stopReason,
+			FinishReason: &reason,
 		}},
 	}
 
 	data, _ := json.Marshal(finalChunk)
 	fmt.Fprintf(w, "data: %s\n\n", data)
 	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
 }
 
-func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, hasFunctionCalling bool) {
+// handleNonStreamResponse 汇总上游流为 OpenAI 响应；超出思考预算时不写响应，返回 reasoningAbort
+func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, opts responseOptions) *reasoningAbort {
 	scanner := bufio.NewScanner(body)
 	scanner.Buffer(make([]byte, 1024*1024), 8*1024*1024)
 	var chunks []string
 	var reasoningChunks []string
 	collectedToolCalls := make([]ToolCall, 0)
 	thinkingFilter := &ThinkingFilter{}
-	searchRefFilter := NewSearchRefFilter()
+	thinkingFilter.StartBudget(opts.budget, body)
+	defer thinkingFilter.StopBudget()
+	searchRefFilter := opts.newSearchRefFilter()
+	hasFunctionCalling := opts.hasFunctionCalling
 	hasThinking := false
 	pendingSourcesMarkdown := ""
 	pendingImageSearchMarkdown := ""
+	var imageSearchResults []ImageSearchResult
+	generatedImages := make(map[string]bool)
+	toolTrace := opts.newToolTrace()
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -848,6 +1075,13 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 			break
 		}
 
+		// 思考预算只在思考阶段计时，内容为空的思考事件也算
+		if upstream.Data.Phase == "thinking" {
+			thinkingFilter.ResumeBudget()
+		} else if upstream.Data.Phase != "" {
+			thinkingFilter.PauseBudget()
+		}
+
 		if upstream.Data.Phase == "thinking" && upstream.Data.DeltaContent != "" {
 			if thinkingFilter.lastPhase != "" && thinkingFilter.lastPhase != "thinking" {
 				thinkingFilter.ResetForNewRound()
@@ -863,6 +1097,9 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 			if reasoningContent != "" {
 				thinkingFilter.lastOutputChunk = reasoningContent
 				reasoningChunks = append(reasoningChunks, reasoningContent)
+				if thinkingFilter.TrackReasoning(reasoningContent) {
+					break
+				}
 			}
 			continue
 		}
@@ -872,6 +1109,9 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 		}
 
 		editContent := upstream.GetEditContent()
+		if toolTrace != nil && editContent != "" {
+			toolTrace.Observe(editContent)
+		}
 		if editContent != "" && IsSearchResultContent(editContent) {
 			if results := ParseSearchResults(editContent); len(results) > 0 {
 				searchRefFilter.AddSearchResults(results)
@@ -886,7 +1126,16 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 			}
 			// 解析图片搜索结果
 			if results := ParseImageSearchResults(editContent); len(results) > 0 {
-				pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				imageSearchResults = append(imageSearchResults, results...)
+				if opts.imageSearchMarkdown {
+					pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				}
+			}
+			continue
+		}
+		if editContent != "" && IsImageGenerationPayload(editContent) {
+			if imageContent := ExtractTextBeforeGlmBlock(editContent) + FormatGeneratedImages(ParseGeneratedImages(editContent), generatedImages); imageContent != "" {
+				chunks = append(chunks, imageContent)
 			}
 			continue
 		}
@@ -942,10 +1191,18 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 		}
 	}
 
-	fullContent := strings.Join(chunks, "")
-	fullContent = searchRefFilter.Process(fullContent) + searchRefFilter.Flush()
+	if thinkingFilter.BudgetExceeded() {
+		partial := searchRefFilter.ProcessReasoning(strings.Join(reasoningChunks, "")) + searchRefFilter.FlushReasoning()
+		return &reasoningAbort{Reasoning: partial}
+	}
+
+	// 与流式一致先处理思考内容，思考中的引用不计入正文的脚注
 	fullReasoning := strings.Join(reasoningChunks, "")
-	fullReasoning = searchRefFilter.Process(fullReasoning) + searchRefFilter.Flush()
+	fullReasoning = opts.priorReasoning + searchRefFilter.ProcessReasoning(fullReasoning) + searchRefFilter.FlushReasoning()
+	fullContent, annotations := searchRefFilter.ProcessContent(strings.Join(chunks, ""))
+	remaining, remainingAnnotations := searchRefFilter.FlushContent()
+	annotations = append(annotations, shiftAnnotations(remainingAnnotations, utf8.RuneCountInString(fullContent))...)
+	fullContent += remaining
 
 	if hasFunctionCalling {
 		if parsedToolCalls, prefixPos := ParseFunctionCallsXML(fullContent); len(parsedToolCalls) > 0 {
@@ -956,15 +1213,51 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 		}
 	}
 
+	// 正文被截断时去掉落在截断部分的注解
+	contentRunes := utf8.RuneCountInString(fullContent)
+	keptAnnotations := annotations[:0]
+	for _, a := range annotations {
+		if a.URLCitation.EndIndex <= contentRunes {
+			a.URLCitation.StartIndex = max(a.URLCitation.StartIndex, 0)
+			keptAnnotations = append(keptAnnotations, a)
+		}
+	}
+	if len(collectedToolCalls) == 0 {
+		fullContent += searchRefFilter.GetCitationFooter()
+	}
+
 	if fullContent == "" {
 		LogError("Non-stream response 200 but no content received")
 	}
 
+	var reasoningSummary *ReasoningSummary
+	switch resolveReasoningFormat(opts.reasoningFormat) {
+	case ReasoningFormatHidden:
+		fullReasoning = ""
+	case ReasoningFormatSummary:
+		if fullReasoning != "" {
+			reasoningSummary = newReasoningSummary(fullReasoning)
+		}
+		fullReasoning = ""
+	case ReasoningFormatThink:
+		if fullReasoning != "" {
+			prefix := thinkOpenTag + fullReasoning + thinkCloseTag
+			keptAnnotations = shiftAnnotations(keptAnnotations, utf8.RuneCountInString(prefix))
+			fullContent = prefix + fullContent
+		}
+		fullReasoning = ""
+	}
+
 	stopReason := "stop"
 	if len(collectedToolCalls) > 0 {
 		stopReason = "tool_calls"
 	}
 
+	var traceEntries []ToolTraceEntry
+	if toolTrace != nil {
+		traceEntries = toolTrace.Entries()
+	}
+
 	var contentPtr *string
 	if fullContent != "" || len(collectedToolCalls) == 0 {
 		contentCopy := fullContent
@@ -983,6 +1276,11 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 				Content:          contentPtr,
 				ReasoningContent: fullReasoning,
 				ToolCalls:        collectedToolCalls,
+				Annotations:      keptAnnotations,
+
+				ImageSearchResults: imageSearchResults,
+				ToolTrace:          traceEntries,
+				Reasoning:          reasoningSummary,
 			},
 			FinishReason: &stopReason,
 		}},
@@ -990,16 +1288,15 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
+	return nil
 }
 
 func HandleModels(w http.ResponseWriter, r *http.Request) {
 	var models []ModelInfo
-	for _, id := range ModelList {
-		models = append(models, ModelInfo{
-			ID:      id,
-			Object:  "model",
-			OwnedBy: "z.ai",
-		})
+	for _, id := range ListModelIDs() {
+		if info, ok := BuildModelInfo(id); ok {
+			models = append(models, info)
+		}
 	}
 
 	response := ModelsResponse{
@@ -1010,3 +1307,21 @@ func HandleModels(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// HandleModel GET /v1/models/{id}
+func HandleModel(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+	if id == "" {
+		HandleModels(w, r)
+		return
+	}
+
+	info, ok := BuildModelInfo(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Model %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
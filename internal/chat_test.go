@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleModelReturnsCanonicalID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HandleModel(rec, httptest.NewRequest("GET", "/v1/models/glm-4.6v", nil))
+	var info ModelInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	if info.ID != "GLM-4.6-V" || info.Alias != "glm-4.6v" {
+		t.Fatalf("GET /v1/models/glm-4.6v = %+v", info)
+	}
+
+	rec = httptest.NewRecorder()
+	HandleModel(rec, httptest.NewRequest("GET", "/v1/models/GLM-4.6-V-search", nil))
+	if rec.Code != 404 {
+		t.Fatalf("GET /v1/models/GLM-4.6-V-search status = %d, want 404", rec.Code)
+	}
+}
+
+// streamContent 运行流式处理并拼接所有 content 和注解
+func streamContent(t *testing.T, upstream string, opts responseOptions) (string, []Annotation) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7", opts)
+	content := ""
+	var annotations []Annotation
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == line || payload == "[DONE]" {
+			continue
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", payload, err)
+		}
+		content += chunk.Choices[0].Delta.Content
+		annotations = append(annotations, chunk.Choices[0].Delta.Annotations...)
+	}
+	return content, annotations
+}
+
+func citations(annotations []Annotation) []URLCitation {
+	var out []URLCitation
+	for _, a := range annotations {
+		out = append(out, *a.URLCitation)
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 引用格式，请求的 citation_format 或 CITATION_FORMAT 配置
+const (
+	CitationFormatMarkdown    = "markdown"    // 行内 markdown 链接 + 开头的来源列表
+	CitationFormatFootnotes   = "footnotes"   // [^n] 脚注，结尾列出引用过的来源
+	CitationFormatPlain       = "plain"       // 只保留 [n] 编号
+	CitationFormatNone        = "none"        // 去掉所有引用
+	CitationFormatAnnotations = "annotations" // 去掉引用标记，改为 url_citation 注解
+)
+
+// CitationRenderer 决定搜索引用在回复文本中的呈现方式
+type CitationRenderer interface {
+	// Ref 替换正文中的一个引用标记
+	Ref(result SearchResult) string
+	// Sources 搜索结果到达时输出的来源列表
+	Sources(results []SearchResult) string
+	// Footer 回复结束时追加的内容，cited 为按首次出现顺序排列的被引用结果
+	Footer(cited []SearchResult) string
+}
+
+var citationRenderers = map[string]CitationRenderer{
+	CitationFormatMarkdown:    markdownCitations{},
+	CitationFormatFootnotes:   footnoteCitations{},
+	CitationFormatPlain:       plainCitations{},
+	CitationFormatNone:        noCitations{},
+	CitationFormatAnnotations: noCitations{},
+}
+
+// ValidCitationFormat 判断引用格式是否支持，空字符串表示使用默认值
+func ValidCitationFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+	_, ok := citationRenderers[format]
+	return ok
+}
+
+// defaultCitationFormat 未指定时使用的引用格式
+func defaultCitationFormat() string {
+	if Cfg == nil || Cfg.CitationFormat == "" {
+		return CitationFormatMarkdown
+	}
+	return Cfg.CitationFormat
+}
+
+// resolveCitationFormat 请求未指定时使用配置的默认值
+func resolveCitationFormat(format string) string {
+	if format == "" {
+		return defaultCitationFormat()
+	}
+	return format
+}
+
+// sortSearchResults 按编号排序
+func sortSearchResults(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+}
+
+type markdownCitations struct{}
+
+func (markdownCitations) Ref(result SearchResult) string {
+	return fmt.Sprintf(`[\[%d\]](%s)`, result.Index, result.URL)
+}
+
+func (markdownCitations) Sources(results []SearchResult) string {
+	var sb strings.Builder
+	for _, r := range results {
+		sb.WriteString(fmt.Sprintf("[\\[%d\\] %s](%s)\n", r.Index, escapeMarkdownTitle(r.Title), r.URL))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (markdownCitations) Footer([]SearchResult) string {
+	return ""
+}
+
+type footnoteCitations struct{}
+
+func (footnoteCitations) Ref(result SearchResult) string {
+	return fmt.Sprintf("[^%d]", result.Index)
+}
+
+func (footnoteCitations) Sources([]SearchResult) string {
+	return ""
+}
+
+func (footnoteCitations) Footer(cited []SearchResult) string {
+	if len(cited) == 0 {
+		return ""
+	}
+	cited = append([]SearchResult(nil), cited...)
+	sortSearchResults(cited)
+
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	for _, r := range cited {
+		title := r.Title
+		if title == "" {
+			title = r.URL
+		}
+		sb.WriteString(fmt.Sprintf("[^%d]: [%s](%s)\n", r.Index, escapeMarkdownTitle(title), r.URL))
+	}
+	return sb.String()
+}
+
+type plainCitations struct{}
+
+func (plainCitations) Ref(result SearchResult) string {
+	return fmt.Sprintf("[%d]", result.Index)
+}
+
+func (plainCitations) Sources([]SearchResult) string {
+	return ""
+}
+
+func (plainCitations) Footer([]SearchResult) string {
+	return ""
+}
+
+type noCitations struct{}
+
+func (noCitations) Ref(SearchResult) string {
+	return ""
+}
+
+func (noCitations) Sources([]SearchResult) string {
+	return ""
+}
+
+func (noCitations) Footer([]SearchResult) string {
+	return ""
+}
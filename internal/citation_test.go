@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCitationAnnotationsAcrossChunks(t *testing.T) {
+	searchResult := `{"search_result":[{"title":"A","url":"https://a.example","index":1,"ref_id":"turn0search1"},{"title":"B","url":"https://b.example","index":2,"ref_id":"turn0search2"}]}`
+	edit, _ := json.Marshal(searchResult)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"北京是中国的首都【turn0"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"search1】。\n上海【turn0search2】【turn0search1】很大"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	want := []URLCitation{
+		{URL: "https://a.example", Title: "A", StartIndex: 0, EndIndex: 8},
+		{URL: "https://b.example", Title: "B", StartIndex: 10, EndIndex: 12},
+		{URL: "https://a.example", Title: "A", StartIndex: 10, EndIndex: 12},
+	}
+	opts := responseOptions{citationFormat: CitationFormatAnnotations}
+
+	rec := httptest.NewRecorder()
+	handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7", opts)
+	var resp ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	msg := resp.Choices[0].Message
+	if *msg.Content != "北京是中国的首都。\n上海很大" {
+		t.Fatalf("content = %q", *msg.Content)
+	}
+	if got := citations(msg.Annotations); !reflect.DeepEqual(got, want) {
+		t.Fatalf("annotations = %+v, want %+v", got, want)
+	}
+
+	content, annotations := streamContent(t, upstream, opts)
+	if content != "北京是中国的首都。\n上海很大" {
+		t.Fatalf("stream content = %q", content)
+	}
+	if got := citations(annotations); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stream annotations = %+v, want %+v", got, want)
+	}
+}
+
+func TestCitationFormats(t *testing.T) {
+	searchResult := `{"search_result":[{"title":"A","url":"https://a.example","index":1,"ref_id":"turn0search1"},{"title":"B","url":"https://b.example","index":2,"ref_id":"turn0search2"}]}`
+	edit, _ := json.Marshal(searchResult)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"北京【turn0search2】，上海【turn0"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"search2】"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	tests := map[string]string{
+		CitationFormatMarkdown:  "[\\[1\\] A](https://a.example)\n[\\[2\\] B](https://b.example)\n\n北京[\\[2\\]](https://b.example)，上海[\\[2\\]](https://b.example)",
+		CitationFormatFootnotes: "北京[^2]，上海[^2]\n\n[^2]: [B](https://b.example)\n",
+		CitationFormatPlain:     "北京[2]，上海[2]",
+		CitationFormatNone:      "北京，上海",
+	}
+	for format, want := range tests {
+		opts := responseOptions{citationFormat: format}
+
+		rec := httptest.NewRecorder()
+		handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7", opts)
+		var resp ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decode response: %v", format, err)
+		}
+		if got := *resp.Choices[0].Message.Content; got != want {
+			t.Errorf("%s: content = %q, want %q", format, got, want)
+		}
+		if got, _ := streamContent(t, upstream, opts); got != want {
+			t.Errorf("%s: stream content = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestReasoningCitationsNotInFootnotes(t *testing.T) {
+	searchResult := `{"search_result":[{"title":"A","url":"https://a.example","index":1,"ref_id":"turn0search1"},{"title":"B","url":"https://b.example","index":2,"ref_id":"turn0search2"}]}`
+	edit, _ := json.Marshal(searchResult)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"thinking","delta_content":"> 参考【turn0search1】"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"北京【turn0search2】"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	want := "北京[^2]\n\n[^2]: [B](https://b.example)\n"
+	opts := responseOptions{citationFormat: CitationFormatFootnotes}
+
+	rec := httptest.NewRecorder()
+	handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7", opts)
+	var resp ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if got := *resp.Choices[0].Message.Content; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+	if got, _ := streamContent(t, upstream, opts); got != want {
+		t.Errorf("stream content = %q, want %q", got, want)
+	}
+}
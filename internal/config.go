@@ -1,7 +1,13 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -9,6 +15,86 @@ import (
 type Config struct {
 	Port     string
 	ProxyURL string
+
+	// 模型发现
+	ModelDiscovery       bool
+	ModelRefreshInterval time.Duration
+	ModelConfigFile      string
+	Models               ModelConfig
+
+	// 能力不匹配时的处理策略：reject / reroute
+	CapabilityPolicy string
+
+	// 图片上传缓存
+	UploadCacheSize int
+	UploadCacheTTL  time.Duration
+
+	// 图片并发上传数；严格模式下任一图片上传失败即拒绝请求
+	UploadConcurrency int
+	UploadStrict      bool
+
+	// 图片预处理：超过限制时缩放并重新编码
+	ImageMaxBytes  int
+	ImageMaxPixels int
+
+	// 拉取用户提供的 URL（图片等）时的 SSRF 防护
+	FetchAllowedHosts []string
+	FetchDeniedHosts  []string
+	FetchAllowPrivate bool
+	FetchMaxBytes     int
+	FetchTimeout      time.Duration
+
+	// 视频输入（下载或 base64 解码）的大小上限
+	VideoMaxBytes int
+
+	// /v1/files 元数据持久化文件，为空时只保存在内存
+	FilesStore string
+
+	// 联网搜索引用的默认格式，请求可用 citation_format 覆盖
+	CitationFormat string
+
+	// 思考内容的默认输出方式，请求可用 reasoning_format 覆盖
+	ReasoningFormat string
+
+	// 思考预算：超出后中止并以非思考模式重新请求，0 表示不限
+	ReasoningBudgetChars   int
+	ReasoningBudgetTokens  int
+	ReasoningBudgetTimeout time.Duration
+	ReasoningBudgetRetry   string // partial / plain
+}
+
+// ModelOverride 对单个模型的能力/映射覆盖，未设置的字段沿用上游或内置值
+type ModelOverride struct {
+	UpstreamID    string `json:"upstream_id,omitempty"`
+	Vision        *bool  `json:"vision,omitempty"`
+	Video         *bool  `json:"video,omitempty"`
+	Thinking      *bool  `json:"thinking,omitempty"`
+	Search        *bool  `json:"search,omitempty"`
+	Tools         *bool  `json:"tools,omitempty"`
+	Documents     *bool  `json:"documents,omitempty"`
+	ContextLength int    `json:"context_length,omitempty"`
+	Hidden        bool   `json:"hidden,omitempty"`
+	// 默认开启的 z.ai MCP 服务，空列表表示关闭
+	MCPServers *[]string `json:"mcp_servers,omitempty"`
+}
+
+// VirtualModel 配置中定义的虚拟模型：基础模型 + 标签 + 默认系统提示词和采样参数
+type VirtualModel struct {
+	Model        string   `json:"model"`
+	Thinking     bool     `json:"thinking,omitempty"`
+	Search       bool     `json:"search,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	TopP         *float64 `json:"top_p,omitempty"`
+	MaxTokens    *int     `json:"max_tokens,omitempty"`
+}
+
+// ModelConfig 对应 MODEL_CONFIG 指向的 JSON 文件
+type ModelConfig struct {
+	Overrides     map[string]ModelOverride `json:"overrides,omitempty"`
+	Aliases       map[string]string        `json:"aliases,omitempty"`
+	VirtualModels map[string]VirtualModel  `json:"virtual_models,omitempty"`
+	Fallbacks     map[string][]string      `json:"fallbacks,omitempty"`
 }
 
 var Cfg *Config
@@ -22,7 +108,139 @@ func LoadConfig() {
 	}
 
 	Cfg = &Config{
-		Port:     port,
-		ProxyURL: os.Getenv("PROXY_URL"),
+		Port:                   port,
+		ProxyURL:               os.Getenv("PROXY_URL"),
+		ModelDiscovery:         parseBoolEnv("MODEL_DISCOVERY", true),
+		ModelRefreshInterval:   parseDurationEnv("MODEL_REFRESH_INTERVAL", 1*time.Hour),
+		ModelConfigFile:        os.Getenv("MODEL_CONFIG"),
+		CapabilityPolicy:       strings.ToLower(os.Getenv("CAPABILITY_POLICY")),
+		UploadCacheSize:        parseIntEnv("UPLOAD_CACHE_SIZE", 512),
+		UploadCacheTTL:         parseDurationEnv("UPLOAD_CACHE_TTL", 1*time.Hour),
+		UploadConcurrency:      parseIntEnv("UPLOAD_CONCURRENCY", 4),
+		UploadStrict:           parseBoolEnv("UPLOAD_STRICT", false),
+		ImageMaxBytes:          parseIntEnv("IMAGE_MAX_BYTES", 10*1024*1024),
+		ImageMaxPixels:         parseIntEnv("IMAGE_MAX_PIXELS", 4096*4096),
+		FetchAllowedHosts:      parseListEnv("FETCH_ALLOWED_HOSTS"),
+		FetchDeniedHosts:       parseListEnv("FETCH_DENIED_HOSTS"),
+		FetchAllowPrivate:      parseBoolEnv("FETCH_ALLOW_PRIVATE", false),
+		FetchMaxBytes:          parseIntEnv("FETCH_MAX_BYTES", 20*1024*1024),
+		FetchTimeout:           parseDurationEnv("FETCH_TIMEOUT", 30*time.Second),
+		FilesStore:             os.Getenv("FILES_STORE"),
+		VideoMaxBytes:          parseIntEnv("VIDEO_MAX_BYTES", 50*1024*1024),
+		CitationFormat:         strings.ToLower(strings.TrimSpace(os.Getenv("CITATION_FORMAT"))),
+		ReasoningFormat:        strings.ToLower(strings.TrimSpace(os.Getenv("REASONING_FORMAT"))),
+		ReasoningBudgetChars:   parseIntEnv("REASONING_BUDGET_CHARS", 0),
+		ReasoningBudgetTokens:  parseIntEnv("REASONING_BUDGET_TOKENS", 0),
+		ReasoningBudgetTimeout: parseDurationEnv("REASONING_BUDGET_TIMEOUT", 0),
+		ReasoningBudgetRetry:   strings.ToLower(strings.TrimSpace(os.Getenv("REASONING_BUDGET_RETRY"))),
+	}
+	if Cfg.CapabilityPolicy != CapabilityPolicyReroute {
+		Cfg.CapabilityPolicy = CapabilityPolicyReject
+	}
+	if !ValidCitationFormat(Cfg.CitationFormat) {
+		LogWarn("Invalid CITATION_FORMAT=%q, using %s", Cfg.CitationFormat, CitationFormatMarkdown)
+		Cfg.CitationFormat = ""
+	}
+	if !ValidReasoningFormat(Cfg.ReasoningFormat) {
+		LogWarn("Invalid REASONING_FORMAT=%q, using %s", Cfg.ReasoningFormat, ReasoningFormatContent)
+		Cfg.ReasoningFormat = ""
+	}
+	if Cfg.ReasoningBudgetRetry != ReasoningRetryPlain {
+		Cfg.ReasoningBudgetRetry = ReasoningRetryPartial
+	}
+
+	if Cfg.ModelConfigFile != "" {
+		if err := loadModelConfig(Cfg.ModelConfigFile, &Cfg.Models); err != nil {
+			LogError("Failed to load model config %s: %v", Cfg.ModelConfigFile, err)
+		}
+	}
+}
+
+func loadModelConfig(path string, out *ModelConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg ModelConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	// 名称查找忽略大小写，只差大小写的键无法确定命中哪一个
+	for _, err := range []error{
+		checkKeyCollisions("overrides", cfg.Overrides),
+		checkKeyCollisions("aliases", cfg.Aliases),
+		checkKeyCollisions("virtual_models", cfg.VirtualModels),
+		checkKeyCollisions("fallbacks", cfg.Fallbacks),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	*out = cfg
+	return nil
+}
+
+// checkKeyCollisions 检查只有大小写不同的键
+func checkKeyCollisions[T any](section string, items map[string]T) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]string, len(keys))
+	for _, key := range keys {
+		folded := strings.ToLower(key)
+		if prev, ok := seen[folded]; ok {
+			return fmt.Errorf("%s: keys %q and %q differ only in case", section, prev, key)
+		}
+		seen[folded] = key
+	}
+	return nil
+}
+
+func parseBoolEnv(key string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	default:
+		return def
+	}
+}
+
+func parseListEnv(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseIntEnv(key string, def int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		LogWarn("Invalid %s=%q, using %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+func parseDurationEnv(key string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		LogWarn("Invalid %s=%q, using %s", key, value, def)
+		return def
 	}
+	return d
 }
@@ -0,0 +1,22 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadModelConfigRejectsCaseCollisions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	if err := os.WriteFile(path, []byte(`{"aliases":{"GPT-4o":"GLM-4.7","gpt-4o":"GLM-5"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var cfg ModelConfig
+	if err := loadModelConfig(path, &cfg); err == nil || !strings.Contains(err.Error(), "differ only in case") {
+		t.Fatalf("loadModelConfig() error = %v", err)
+	}
+	if cfg.Aliases != nil {
+		t.Fatalf("colliding config applied: %v", cfg.Aliases)
+	}
+}
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DocumentUploadError 文档上传失败，Index 为文档在请求中的序号
+type DocumentUploadError struct {
+	Index    int
+	Filename string
+	Err      error
+}
+
+func (e *DocumentUploadError) Error() string {
+	name := e.Filename
+	if name == "" {
+		name = "unnamed"
+	}
+	return fmt.Sprintf("document #%d (%s) failed to upload: %v", e.Index, name, e.Err)
+}
+
+func (e *DocumentUploadError) Unwrap() error {
+	return e.Err
+}
+
+// 通用类型（zip、二进制）需要按扩展名细化，例如 docx/xlsx 会被识别为 zip
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"application/zip":          true,
+	"text/plain":               true,
+}
+
+// sniffDocumentType 按内容识别文档类型，内容无法区分时参考文件名和声明的类型
+func sniffDocumentType(data []byte, filename, declared string) string {
+	contentType := sniffImageType(data)
+	if !genericContentTypes[contentType] {
+		return contentType
+	}
+	if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExt != "" {
+		if idx := strings.Index(byExt, ";"); idx != -1 {
+			byExt = byExt[:idx]
+		}
+		return byExt
+	}
+	if declared != "" {
+		return declared
+	}
+	return contentType
+}
+
+// documentFilename 文件名缺失或扩展名与类型不符时补全
+func documentFilename(filename, contentType string) string {
+	filename = filepath.Base(strings.TrimSpace(filename))
+	if filename == "" || filename == "." || filename == "/" {
+		filename = uuid.New().String()[:12]
+	}
+	if filepath.Ext(filename) != "" {
+		return filename
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return filename + exts[0]
+	}
+	return filename
+}
+
+// decodeFileData file_data 可以是 data URL，也可以是纯 base64
+func decodeFileData(fileData string) ([]byte, string, error) {
+	if strings.HasPrefix(fileData, "data:") {
+		return decodeDataURL(fileData)
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(fileData))) > fetchMaxBytes() {
+		return nil, "", fmt.Errorf("base64 data exceeds limit %d", fetchMaxBytes())
+	}
+	data, err := base64.StdEncoding.DecodeString(fileData)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode base64: %v", err)
+	}
+	return data, "", nil
+}
+
+// UploadDocument 上传 OpenAI file 内容项中的文档（PDF、文本、代码等）
+// 内容为图片时按图片流程预处理并以 image 类型上传
+func UploadDocument(token string, input FileInput) (*UpstreamFile, error) {
+	if input.FileID != "" {
+		// 通过 /v1/files 上传过的文件直接复用，不再请求上游
+		if stored, ok := GetFileStore().Get(input.FileID, tokenUserID(token)); ok {
+			file := stored.Upstream
+			file.ItemID = uuid.New().String()
+			return &file, nil
+		}
+		return GetUploadedFile(token, input.FileID)
+	}
+
+	data, declared, err := decodeFileData(input.FileData)
+	if err != nil {
+		return nil, err
+	}
+	return uploadDocumentData(token, data, input.Filename, declared)
+}
+
+// uploadDocumentData 识别类型后上传文档数据，相同内容复用缓存
+func uploadDocumentData(token string, data []byte, filename, declared string) (*UpstreamFile, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+
+	cache := GetUploadCache()
+	contentKey := uploadCacheKey(tokenUserID(token), "sha256", data)
+	if file, ok := cache.Get(contentKey); ok {
+		return file, nil
+	}
+
+	media := "file"
+	contentType := sniffDocumentType(data, filename, declared)
+	filename = documentFilename(filename, contentType)
+	if strings.HasPrefix(contentType, "image/") {
+		var err error
+		media = "image"
+		if data, contentType, err = preprocessImage(data); err != nil {
+			return nil, err
+		}
+		filename = imageFilename(filename, contentType)
+	}
+
+	uploadResp, err := uploadFileData(token, data, filename, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newUpstreamFile(uploadResp, media)
+	cache.Put(contentKey, file)
+	return file, nil
+}
+
+// UploadDocuments 按顺序上传文档，任一失败即返回 DocumentUploadError
+func UploadDocuments(token string, inputs []FileInput) ([]*UpstreamFile, error) {
+	files := make([]*UpstreamFile, 0, len(inputs))
+	seen := make(map[string]bool)
+	for i, input := range inputs {
+		file, err := UploadDocument(token, input)
+		if err != nil {
+			LogError("Failed to upload document #%d %s: %v", i, input.Filename, err)
+			return nil, &DocumentUploadError{Index: i, Filename: input.Filename, Err: err}
+		}
+		// 同一文档在历史中出现多次，上游只需要一份
+		if seen[file.ID] {
+			continue
+		}
+		seen[file.ID] = true
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// upstreamFileIDPattern z.ai 文件 ID 为 UUID，这里只限制字符集
+var upstreamFileIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// upstreamFileURL 拼接 z.ai 文件接口地址，ID 含路径或查询字符时拒绝
+func upstreamFileURL(fileID string) (string, error) {
+	if !upstreamFileIDPattern.MatchString(fileID) {
+		return "", fmt.Errorf("invalid file id %q", fileID)
+	}
+	return "https://chat.z.ai/api/v1/files/" + url.PathEscape(fileID), nil
+}
+
+// GetUploadedFile 查询 z.ai 上已上传文件的元数据
+func GetUploadedFile(token, fileID string) (*UpstreamFile, error) {
+	fileURL, err := upstreamFileURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Origin", "https://chat.z.ai")
+	req.Header.Set("Referer", "https://chat.z.ai/")
+
+	client := GetStickyProxyClient(token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file %s: %v", fileID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := string(body)
+		if len(bodyStr) > 500 {
+			bodyStr = bodyStr[:500]
+		}
+		return nil, &UploadHTTPError{StatusCode: resp.StatusCode, Body: bodyStr}
+	}
+
+	var fileResp FileUploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&fileResp); err != nil {
+		return nil, fmt.Errorf("failed to parse file response: %v", err)
+	}
+
+	media := "file"
+	if strings.HasPrefix(fileResp.Meta.ContentType, "image/") {
+		media = "image"
+	}
+	return newUpstreamFile(&fileResp, media), nil
+}
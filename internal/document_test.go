@@ -0,0 +1,17 @@
+package internal
+
+import "testing"
+
+func TestUpstreamFileURLRejectsPathCharacters(t *testing.T) {
+	if got, err := upstreamFileURL("0b6f4c1e-5f0a-4c5e-9d2a-1f3e2b7c8d9e"); err != nil || got != "https://chat.z.ai/api/v1/files/0b6f4c1e-5f0a-4c5e-9d2a-1f3e2b7c8d9e" {
+		t.Fatalf("upstreamFileURL(uuid) = (%q, %v)", got, err)
+	}
+	for _, id := range []string{"", "../chats/x", "x?y=1", "a/b", "a#b", "a%2Fb"} {
+		if _, err := upstreamFileURL(id); err == nil {
+			t.Fatalf("upstreamFileURL(%q) should be rejected", id)
+		}
+	}
+	if _, err := GetUploadedFile("token", "../chats/x"); err == nil {
+		t.Fatalf("GetUploadedFile should reject path traversal")
+	}
+}
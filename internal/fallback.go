@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// FallbackHeader 发生降级时返回实际服务的模型
+const FallbackHeader = "X-Zai-Proxy-Fallback"
+
+// UpstreamStatusError 上游在开始流式输出前返回非 200
+type UpstreamStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream error: status %d, body: %s", e.StatusCode, e.Body)
+}
+
+// UpstreamTransportError 请求上游时的网络错误，可以降级重试
+type UpstreamTransportError struct {
+	Err error
+}
+
+func (e *UpstreamTransportError) Error() string {
+	return fmt.Sprintf("upstream request failed: %v", e.Err)
+}
+
+func (e *UpstreamTransportError) Unwrap() error {
+	return e.Err
+}
+
+// shouldFallback 只有限流和上游 5xx 才换模型重试，4xx 换模型也不会成功
+func shouldFallback(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func modelFallbacks() map[string][]string {
+	if Cfg == nil {
+		return nil
+	}
+	return Cfg.Models.Fallbacks
+}
+
+// fallbackCandidates 返回请求模型及其降级链，降级模型继承原请求的标签
+func fallbackCandidates(model string) []string {
+	candidates := []string{model}
+	baseModel, enableThinking, enableSearch := ParseModelName(model)
+	_, chain, ok := lookupConfigKey(modelFallbacks(), baseModel)
+	if !ok {
+		return candidates
+	}
+
+	for _, next := range chain {
+		nextBase, nextThinking, nextSearch := ParseModelName(ResolveModelAlias(next))
+		candidates = append(candidates, FormatModelName(nextBase, enableThinking || nextThinking, enableSearch || nextSearch))
+	}
+	return candidates
+}
+
+// requestWithFallback 依次尝试请求模型及其降级链，返回第一个 200 的响应
+// 只在网络错误、429 和 5xx 时降级，其余错误直接返回
+// 返回值：响应、上游模型 ID、实际服务的模型名
+func requestWithFallback(token string, req ChatRequest) (*http.Response, string, string, error) {
+	var lastErr error
+	for i, candidate := range fallbackCandidates(req.Model) {
+		attempt := req
+		if i > 0 {
+			resolved, err := ResolveRequestModel(candidate, req.Messages, req.Tools)
+			if err != nil {
+				LogWarn("[Fallback] skip %s: %v", candidate, err)
+				continue
+			}
+			attempt.Model = resolved
+			LogWarn("[Fallback] %s failed, retrying with %s", req.Model, attempt.Model)
+		}
+
+		resp, modelName, err := makeUpstreamRequest(token, attempt)
+		if err != nil {
+			// 上传失败等与模型无关的错误，降级无意义
+			var transportErr *UpstreamTransportError
+			if !errors.As(err, &transportErr) {
+				return nil, "", "", err
+			}
+			LogError("Upstream request failed (%s): %v", attempt.Model, err)
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodyStr := string(body)
+			if len(bodyStr) > 500 {
+				bodyStr = bodyStr[:500]
+			}
+			LogError("Upstream error (%s): status=%d, body=%s", attempt.Model, resp.StatusCode, bodyStr)
+			lastErr = &UpstreamStatusError{StatusCode: resp.StatusCode, Body: bodyStr}
+			if !shouldFallback(resp.StatusCode) {
+				return nil, "", "", lastErr
+			}
+			continue
+		}
+
+		return resp, modelName, attempt.Model, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no usable model for %s", req.Model)
+	}
+	return nil, "", "", lastErr
+}
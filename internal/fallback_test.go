@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShouldFallback(t *testing.T) {
+	for status, want := range map[int]bool{400: false, 401: false, 403: false, 404: false, 429: true, 500: true, 502: true, 503: true} {
+		if got := shouldFallback(status); got != want {
+			t.Fatalf("shouldFallback(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestFallbackCandidatesInheritTags(t *testing.T) {
+	originCfg := Cfg
+	Cfg = &Config{Models: ModelConfig{
+		Fallbacks: map[string][]string{"GLM-5": {"GLM-4.7", "GLM-4.6"}},
+	}}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	got := fallbackCandidates("GLM-5-thinking")
+	want := []string{"GLM-5-thinking", "GLM-4.7-thinking", "GLM-4.6-thinking"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("fallbackCandidates = %v, want %v", got, want)
+	}
+
+	if got := fallbackCandidates("GLM-4.7"); len(got) != 1 {
+		t.Fatalf("fallbackCandidates(GLM-4.7) = %v, want only itself", got)
+	}
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrFetchBlocked 目标地址被 SSRF 规则拦截
+var ErrFetchBlocked = errors.New("fetch blocked")
+
+// 除 net.IP 自带判断外额外拦截的网段
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // 本网络
+	"100.64.0.0/10", // 运营商级 NAT
+	"192.0.0.0/24",  // IETF 协议分配
+	"198.18.0.0/15", // 基准测试
+	"64:ff9b::/96",  // NAT64
+)
+
+var (
+	fetchClient     *http.Client
+	fetchClientOnce sync.Once
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func fetchAllowPrivate() bool {
+	return Cfg != nil && Cfg.FetchAllowPrivate
+}
+
+func fetchMaxBytes() int64 {
+	if Cfg == nil || Cfg.FetchMaxBytes <= 0 {
+		return 20 * 1024 * 1024
+	}
+	return int64(Cfg.FetchMaxBytes)
+}
+
+func fetchTimeout() time.Duration {
+	if Cfg == nil || Cfg.FetchTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return Cfg.FetchTimeout
+}
+
+// isBlockedIP 回环、私有、链路本地（含云厂商元数据 169.254.169.254）等地址一律拦截
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostPattern 支持精确匹配和 *.example.com 形式的子域名匹配
+func matchHostPattern(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFetchURL 校验协议、主机黑白名单，并解析域名检查所有 IP
+func validateFetchURL(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q not allowed", ErrFetchBlocked, u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrFetchBlocked)
+	}
+
+	if Cfg != nil {
+		if matchHostPattern(host, Cfg.FetchDeniedHosts) {
+			return fmt.Errorf("%w: host %s is denied", ErrFetchBlocked, host)
+		}
+		if len(Cfg.FetchAllowedHosts) > 0 && !matchHostPattern(host, Cfg.FetchAllowedHosts) {
+			return fmt.Errorf("%w: host %s is not in the allow list", ErrFetchBlocked, host)
+		}
+	}
+
+	if fetchAllowPrivate() {
+		return nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", host, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if isBlockedIP(ip) {
+			return fmt.Errorf("%w: %s resolves to non-public address %s", ErrFetchBlocked, host, ip)
+		}
+	}
+	return nil
+}
+
+// dialControl 在真正建立连接时再次检查目标 IP，防止 DNS rebinding
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if fetchAllowPrivate() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && isBlockedIP(ip) {
+		return fmt.Errorf("%w: connection to %s", ErrFetchBlocked, ip)
+	}
+	return nil
+}
+
+func newFetchClient(proxyStr string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxyStr != "" {
+		// 走代理时连接的是代理地址，只能依赖请求前和重定向时的解析检查
+		if proxyURL, err := url.Parse(proxyStr); err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	} else {
+		transport.Proxy = nil
+		dialer := &net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialControl,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   fetchTimeout(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			return validateFetchURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// GetFetchClient 返回拉取用户提供 URL 的 HTTP Client (单例)
+func GetFetchClient() *http.Client {
+	fetchClientOnce.Do(func() {
+		proxyStr := ""
+		if Cfg != nil {
+			proxyStr = Cfg.ProxyURL
+		}
+		fetchClient = newFetchClient(proxyStr)
+	})
+	return fetchClient
+}
+
+// FetchRemote 安全地下载用户提供的 URL，返回内容和 Content-Type
+// maxBytes <= 0 时使用 FETCH_MAX_BYTES
+func FetchRemote(rawURL string, maxBytes int64) ([]byte, string, error) {
+	body, contentType, _, err := OpenRemote(rawURL, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentType, nil
+}
+
+// remoteBody 读取超过上限时报错，关闭时取消请求
+type remoteBody struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	limit  int64
+	read   int64
+}
+
+func (b *remoteBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.limit-b.read+1 {
+		p = p[:b.limit-b.read+1]
+	}
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, fmt.Errorf("content exceeds limit %d", b.limit)
+	}
+	return n, err
+}
+
+func (b *remoteBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// OpenRemote 安全地打开用户提供的 URL，返回流式读取的内容、Content-Type 和长度（未知时为 -1）
+// 调用方负责关闭；maxBytes <= 0 时使用 FETCH_MAX_BYTES
+func OpenRemote(rawURL string, maxBytes int64) (io.ReadCloser, string, int64, error) {
+	if maxBytes <= 0 {
+		maxBytes = fetchMaxBytes()
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("invalid url: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout())
+
+	if err := validateFetchURL(ctx, u); err != nil {
+		cancel()
+		return nil, "", 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, "", 0, err
+	}
+
+	resp, err := GetFetchClient().Do(req)
+	if err != nil {
+		cancel()
+		return nil, "", 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, "", 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		resp.Body.Close()
+		cancel()
+		return nil, "", 0, fmt.Errorf("content length %d exceeds limit %d", resp.ContentLength, maxBytes)
+	}
+
+	body := &remoteBody{body: resp.Body, cancel: cancel, limit: maxBytes}
+	return body, resp.Header.Get("Content-Type"), resp.ContentLength, nil
+}
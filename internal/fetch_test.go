@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchRemoteBlocksInternalAddresses(t *testing.T) {
+	originCfg := Cfg
+	Cfg = &Config{FetchDeniedHosts: []string{"*.internal.example"}}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	blocked := []string{
+		server.URL,
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+		"http://[::1]/",
+		"http://100.64.1.1/",
+		"file:///etc/passwd",
+		"https://api.internal.example/a.png",
+	}
+	for _, rawURL := range blocked {
+		if _, _, err := FetchRemote(rawURL, 0); !errors.Is(err, ErrFetchBlocked) {
+			t.Fatalf("FetchRemote(%q) error = %v, want ErrFetchBlocked", rawURL, err)
+		}
+	}
+}
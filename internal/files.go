@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StoredFile 通过 /v1/files 上传的文件元数据，ID 在本地生成，相同内容的多次上传可能共用一个 z.ai 文件
+type StoredFile struct {
+	ID        string       `json:"id"`
+	UserID    string       `json:"user_id"`
+	Filename  string       `json:"filename"`
+	Purpose   string       `json:"purpose"`
+	Bytes     int64        `json:"bytes"`
+	CreatedAt int64        `json:"created_at"`
+	Upstream  UpstreamFile `json:"upstream"`
+}
+
+// FileObject OpenAI 格式的文件对象
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+}
+
+type FileListResponse struct {
+	Object string       `json:"object"`
+	Data   []FileObject `json:"data"`
+}
+
+type FileDeleteResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (f *StoredFile) toObject() FileObject {
+	return FileObject{
+		ID:        f.ID,
+		Object:    "file",
+		Bytes:     f.Bytes,
+		CreatedAt: f.CreatedAt,
+		Filename:  f.Filename,
+		Purpose:   f.Purpose,
+		Status:    "processed",
+	}
+}
+
+// FileStore 文件元数据存储，设置 FILES_STORE 时每次变更写回 JSON 文件
+type FileStore struct {
+	mu    sync.RWMutex
+	path  string
+	files map[string]*StoredFile
+}
+
+var (
+	fileStore     *FileStore
+	fileStoreOnce sync.Once
+)
+
+// GetFileStore 返回文件元数据存储 (单例)
+func GetFileStore() *FileStore {
+	fileStoreOnce.Do(func() {
+		path := ""
+		if Cfg != nil {
+			path = Cfg.FilesStore
+		}
+		fileStore = newFileStore(path)
+	})
+	return fileStore
+}
+
+func newFileStore(path string) *FileStore {
+	s := &FileStore{path: path, files: make(map[string]*StoredFile)}
+	if path == "" {
+		return s
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			LogError("Failed to read files store %s: %v", path, err)
+		}
+		return s
+	}
+	var files []*StoredFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		LogError("Failed to parse files store %s: %v", path, err)
+		return s
+	}
+	for _, f := range files {
+		s.files[f.ID] = f
+	}
+	LogInfo("Loaded %d files from %s", len(files), path)
+	return s
+}
+
+// save 调用方需持有锁；先写临时文件再重命名，避免写一半
+func (s *FileStore) save() {
+	if s.path == "" {
+		return
+	}
+	files := make([]*StoredFile, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt < files[j].CreatedAt })
+
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		LogError("Failed to encode files store: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".files-*.json")
+	if err != nil {
+		LogError("Failed to write files store: %v", err)
+		return
+	}
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tmp.Name())
+		LogError("Failed to write files store: %v %v", writeErr, closeErr)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		LogError("Failed to write files store: %v", err)
+	}
+}
+
+func (s *FileStore) Add(f *StoredFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[f.ID] = f
+	s.save()
+}
+
+// Get 只返回属于 userID 的文件
+func (s *FileStore) Get(id, userID string) (*StoredFile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.files[id]
+	if !ok || f.UserID != userID {
+		return nil, false
+	}
+	return f, true
+}
+
+// List 按创建时间倒序返回 userID 的文件，purpose 为空时不过滤
+func (s *FileStore) List(userID, purpose string) []*StoredFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var files []*StoredFile
+	for _, f := range s.files {
+		if f.UserID != userID || (purpose != "" && f.Purpose != purpose) {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt > files[j].CreatedAt })
+	return files
+}
+
+// UpstreamInUse 判断是否还有文件记录引用 z.ai 文件
+func (s *FileStore) UpstreamInUse(upstreamID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.files {
+		if f.Upstream.ID == upstreamID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FileStore) Delete(id, userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok || f.UserID != userID {
+		return false
+	}
+	delete(s.files, id)
+	s.save()
+	return true
+}
+
+// deleteUpstreamFile 删除 z.ai 上的文件，失败只记录日志
+func deleteUpstreamFile(token, fileID string) {
+	fileURL, err := upstreamFileURL(fileID)
+	if err != nil {
+		LogWarn("Skip deleting upstream file: %v", err)
+		return
+	}
+	req, err := http.NewRequest("DELETE", fileURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Origin", "https://chat.z.ai")
+	req.Header.Set("Referer", "https://chat.z.ai/")
+
+	resp, err := GetStickyProxyClient(token).Do(req)
+	if err != nil {
+		LogWarn("Failed to delete upstream file %s: %v", fileID, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		LogWarn("Failed to delete upstream file %s: status %d", fileID, resp.StatusCode)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// HandleFiles 处理 GET /v1/files（列表）和 POST /v1/files（上传）
+func HandleFiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token, ok := requestToken(w, r)
+		if !ok {
+			return
+		}
+		files := GetFileStore().List(tokenUserID(token), r.URL.Query().Get("purpose"))
+		resp := FileListResponse{Object: "list", Data: make([]FileObject, 0, len(files))}
+		for _, f := range files {
+			resp.Data = append(resp.Data, f.toObject())
+		}
+		writeJSON(w, resp)
+	case http.MethodPost:
+		handleFileUpload(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleFileUpload(w http.ResponseWriter, r *http.Request) {
+	token, ok := requestToken(w, r)
+	if !ok {
+		return
+	}
+
+	// 预留 1MB 给其他表单字段
+	r.Body = http.MaxBytesReader(w, r.Body, fetchMaxBytes()+1024*1024)
+	part, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Missing file: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
+
+	data, err := io.ReadAll(io.LimitReader(part, fetchMaxBytes()+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > fetchMaxBytes() {
+		http.Error(w, fmt.Sprintf("File exceeds limit %d bytes", fetchMaxBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	purpose := r.FormValue("purpose")
+	if purpose == "" {
+		purpose = "user_data"
+	}
+
+	file, err := uploadDocumentData(token, data, header.Filename, header.Header.Get("Content-Type"))
+	if err != nil {
+		LogError("Failed to upload file %s: %v", header.Filename, err)
+		// 文件本身的问题返回 400，z.ai 拒绝或不可用返回 502
+		var httpErr *UploadHTTPError
+		switch {
+		case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized:
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case errors.As(err, &httpErr) || errors.Is(err, ErrUploadUnavailable):
+			http.Error(w, fmt.Sprintf("Failed to upload file: %v", err), http.StatusBadGateway)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to upload file: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+
+	stored := &StoredFile{
+		ID:        newStoredFileID(),
+		UserID:    tokenUserID(token),
+		Filename:  header.Filename,
+		Purpose:   purpose,
+		Bytes:     int64(len(data)),
+		CreatedAt: time.Now().Unix(),
+		Upstream:  *file,
+	}
+	GetFileStore().Add(stored)
+	LogInfo("[Files] uploaded %s as %s (%d bytes)", header.Filename, stored.ID, stored.Bytes)
+
+	writeJSON(w, stored.toObject())
+}
+
+// HandleFile 处理 GET / DELETE /v1/files/{id}
+func HandleFile(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	if id == "" {
+		HandleFiles(w, r)
+		return
+	}
+
+	token, ok := requestToken(w, r)
+	if !ok {
+		return
+	}
+	userID := tokenUserID(token)
+
+	switch r.Method {
+	case http.MethodGet:
+		f, ok := GetFileStore().Get(id, userID)
+		if !ok {
+			http.Error(w, fmt.Sprintf("File %s not found", id), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, f.toObject())
+	case http.MethodDelete:
+		f, ok := GetFileStore().Get(id, userID)
+		if !ok || !GetFileStore().Delete(id, userID) {
+			http.Error(w, fmt.Sprintf("File %s not found", id), http.StatusNotFound)
+			return
+		}
+		// 其他记录仍引用同一个 z.ai 文件时保留；上游文件删除后缓存中的记录不能再复用
+		if upstreamID := f.Upstream.ID; upstreamID != "" && !GetFileStore().UpstreamInUse(upstreamID) {
+			if n := GetUploadCache().RemoveFileID(upstreamID); n > 0 {
+				LogDebug("[UploadCache] evicted %d entries for deleted file %s", n, upstreamID)
+			}
+			deleteUpstreamFile(token, upstreamID)
+		}
+		writeJSON(w, FileDeleteResponse{ID: id, Object: "file", Deleted: true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// newStoredFileID 生成 /v1/files 返回的文件 ID
+func newStoredFileID() string {
+	return "file-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
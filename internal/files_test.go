@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStorePersistsAndScopesByUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "files.json")
+	store := newFileStore(path)
+	store.Add(&StoredFile{ID: "f1", UserID: "alice", Filename: "a.pdf", Purpose: "user_data", CreatedAt: 1,
+		Upstream: UpstreamFile{ID: "f1", Media: "file"}})
+	store.Add(&StoredFile{ID: "f2", UserID: "alice", Filename: "b.png", Purpose: "vision", CreatedAt: 2})
+	store.Add(&StoredFile{ID: "f3", UserID: "bob", Filename: "c.txt", Purpose: "user_data", CreatedAt: 3})
+
+	reloaded := newFileStore(path)
+	f, ok := reloaded.Get("f1", "alice")
+	if !ok || f.Filename != "a.pdf" || f.Upstream.Media != "file" {
+		t.Fatalf("Get(f1) after reload = (%+v, %v)", f, ok)
+	}
+	if _, ok := reloaded.Get("f1", "bob"); ok {
+		t.Fatalf("bob should not see alice's file")
+	}
+
+	files := reloaded.List("alice", "")
+	if len(files) != 2 || files[0].ID != "f2" {
+		t.Fatalf("List(alice) = %+v, want newest first", files)
+	}
+	if files := reloaded.List("alice", "vision"); len(files) != 1 || files[0].ID != "f2" {
+		t.Fatalf("List(alice, vision) = %+v", files)
+	}
+
+	if reloaded.Delete("f3", "alice") {
+		t.Fatalf("alice should not delete bob's file")
+	}
+	if !reloaded.Delete("f1", "alice") {
+		t.Fatalf("Delete(f1) failed")
+	}
+	if _, ok := newFileStore(path).Get("f1", "alice"); ok {
+		t.Fatalf("deleted file should not be persisted")
+	}
+}
+
+func TestFilesHandlerLifecycle(t *testing.T) {
+	originStore, originCache := GetFileStore(), GetUploadCache()
+	fileStore, uploadCacheInstance = newFileStore(""), newUploadCache(16, time.Hour)
+	t.Cleanup(func() {
+		fileStore, uploadCacheInstance = originStore, originCache
+	})
+
+	var deleted []string
+	uploadStatus := http.StatusOK
+	useFakeUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/files/":
+			if uploadStatus != http.StatusOK {
+				http.Error(w, "rejected", uploadStatus)
+				return
+			}
+			w.Write([]byte(`{"id":"0b6f4c1e-up","filename":"notes.txt","meta":{"size":11}}`))
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/v1/files/"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	token := fakeToken("alice")
+	do := func(method, path string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		HandleFile(rec, req)
+		return rec
+	}
+	upload := func(data []byte) *httptest.ResponseRecorder {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		part, _ := mw.CreateFormFile("file", "notes.txt")
+		part.Write(data)
+		mw.WriteField("purpose", "user_data")
+		mw.Close()
+		return do(http.MethodPost, "/v1/files/", &form, mw.FormDataContentType())
+	}
+
+	// 相同内容上传两次，复用同一个 z.ai 文件，但各有自己的 ID
+	data := []byte("hello world")
+	var first, second FileObject
+	rec := upload(data)
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || !strings.HasPrefix(first.ID, "file-") || first.Bytes != 11 {
+		t.Fatalf("POST /v1/files = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = upload(data)
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil || second.ID == first.ID {
+		t.Fatalf("second POST /v1/files = %d %s", rec.Code, rec.Body.String())
+	}
+	if stored, ok := GetFileStore().Get(second.ID, "alice"); !ok || stored.Upstream.ID != "0b6f4c1e-up" {
+		t.Fatalf("stored file = %+v", stored)
+	}
+
+	if rec := do(http.MethodGet, "/v1/files/"+first.ID, nil, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"notes.txt"`) {
+		t.Fatalf("GET /v1/files/{id} = %d %s", rec.Code, rec.Body.String())
+	}
+	var list FileListResponse
+	rec = do(http.MethodGet, "/v1/files/", nil, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Data) != 2 {
+		t.Fatalf("GET /v1/files = %d %s", rec.Code, rec.Body.String())
+	}
+
+	contentKey := uploadCacheKey("alice", "sha256", data)
+	if _, ok := GetUploadCache().Get(contentKey); !ok {
+		t.Fatalf("upload should be cached")
+	}
+
+	// 另一条记录仍引用上游文件，不删除上游
+	rec = do(http.MethodDelete, "/v1/files/"+first.ID, nil, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"deleted":true`) {
+		t.Fatalf("DELETE /v1/files/{id} = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("upstream file deleted while still referenced: %v", deleted)
+	}
+	if _, ok := GetUploadCache().Get(contentKey); !ok {
+		t.Fatalf("upload cache evicted while still referenced")
+	}
+
+	if rec := do(http.MethodDelete, "/v1/files/"+second.ID, nil, ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /v1/files/{id} = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(deleted) != 1 || deleted[0] != "0b6f4c1e-up" {
+		t.Fatalf("upstream deletes = %v", deleted)
+	}
+	if _, ok := GetUploadCache().Get(contentKey); ok {
+		t.Fatalf("deleted file still in upload cache")
+	}
+	if rec := do(http.MethodGet, "/v1/files/"+first.ID, nil, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET after delete = %d, want 404", rec.Code)
+	}
+
+	// 上游失败返回 502，401 原样返回，文件本身的问题返回 400
+	for status, want := range map[int]int{
+		http.StatusInternalServerError:   http.StatusBadGateway,
+		http.StatusRequestEntityTooLarge: http.StatusBadGateway,
+		http.StatusUnauthorized:          http.StatusUnauthorized,
+	} {
+		uploadStatus = status
+		if rec := upload([]byte(fmt.Sprintf("status %d", status))); rec.Code != want {
+			t.Errorf("upstream %d: POST /v1/files = %d, want %d", status, rec.Code, want)
+		}
+	}
+	if rec := upload(nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty file: POST /v1/files = %d, want 400", rec.Code)
+	}
+}
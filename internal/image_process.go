@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// z.ai 可直接接收的图片类型，其余类型需要转码
+var uploadableImageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func imageMaxBytes() int {
+	if Cfg == nil || Cfg.ImageMaxBytes <= 0 {
+		return 10 * 1024 * 1024
+	}
+	return Cfg.ImageMaxBytes
+}
+
+func imageMaxPixels() int {
+	if Cfg == nil || Cfg.ImageMaxPixels <= 0 {
+		return 4096 * 4096
+	}
+	return Cfg.ImageMaxPixels
+}
+
+// 解码后按 RGBA 每像素 4 字节占用内存，超出上限 2 倍或绝对上限（约 256 MiB）的图片不解码
+const (
+	imageDecodeFactor    = 2
+	imageDecodeMaxPixels = 64 << 20
+)
+
+func imageDecodeLimit() int {
+	return min(imageMaxPixels()*imageDecodeFactor, imageDecodeMaxPixels)
+}
+
+// sniffImageType 按内容识别图片类型，不信任 Content-Type 或 data URL 前缀
+func sniffImageType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "mif1", "msf1":
+			return "image/heic"
+		case "avif":
+			return "image/avif"
+		}
+	}
+	contentType := http.DetectContentType(data)
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	return contentType
+}
+
+// preprocessImage 校验并规范化图片：识别真实类型，超出像素/大小限制时缩放重编码，
+// 非 png/jpeg/gif/webp 的格式（如 bmp、tiff）转为 png/jpeg
+func preprocessImage(data []byte) ([]byte, string, error) {
+	contentType := sniffImageType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("not an image (detected %s)", contentType)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported image type %s: %v", contentType, err)
+	}
+
+	maxPixels := imageMaxPixels()
+	maxBytes := imageMaxBytes()
+	pixels := cfg.Width * cfg.Height
+	// 防止解压炸弹：远超上限的尺寸不解码
+	if pixels > imageDecodeLimit() {
+		return nil, "", fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	_, uploadable := uploadableImageTypes[contentType]
+	if uploadable && pixels <= maxPixels && len(data) <= maxBytes {
+		return data, contentType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+
+	if pixels > maxPixels {
+		scale := math.Sqrt(float64(maxPixels) / float64(pixels))
+		img = resizeImage(img, scale)
+	}
+
+	encoded, encodedType, err := encodeImageWithinLimit(img, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	LogDebug("[Image] preprocessed %s %dx%d (%d bytes) -> %s %dx%d (%d bytes)",
+		contentType, cfg.Width, cfg.Height, len(data),
+		encodedType, img.Bounds().Dx(), img.Bounds().Dy(), len(encoded))
+	return encoded, encodedType, nil
+}
+
+func resizeImage(img image.Image, scale float64) image.Image {
+	bounds := img.Bounds()
+	width := max(1, int(float64(bounds.Dx())*scale))
+	height := max(1, int(float64(bounds.Dy())*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// encodeImageWithinLimit 有透明通道编码为 png，否则 jpeg；超过大小时降低质量或继续缩小
+func encodeImageWithinLimit(img image.Image, maxBytes int) ([]byte, string, error) {
+	for attempt := 0; attempt < 4; attempt++ {
+		var buf bytes.Buffer
+		if hasAlpha(img) {
+			if err := png.Encode(&buf, img); err != nil {
+				return nil, "", fmt.Errorf("failed to encode png: %v", err)
+			}
+			if buf.Len() <= maxBytes {
+				return buf.Bytes(), "image/png", nil
+			}
+		} else {
+			for _, quality := range []int{85, 70, 55} {
+				buf.Reset()
+				if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+					return nil, "", fmt.Errorf("failed to encode jpeg: %v", err)
+				}
+				if buf.Len() <= maxBytes {
+					return buf.Bytes(), "image/jpeg", nil
+				}
+			}
+		}
+		img = resizeImage(img, 0.7)
+	}
+	return nil, "", fmt.Errorf("image exceeds %d bytes after downscaling", maxBytes)
+}
+
+func hasAlpha(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	return true
+}
+
+// imageFilename 使文件扩展名与真实类型一致
+func imageFilename(filename, contentType string) string {
+	ext, ok := uploadableImageTypes[contentType]
+	if !ok {
+		return filename
+	}
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if base == "" {
+		base = "image"
+	}
+	return base + ext
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestPreprocessImageConvertsAndDownscales(t *testing.T) {
+	originCfg := Cfg
+	Cfg = &Config{ImageMaxBytes: 1024 * 1024, ImageMaxPixels: 96 * 96}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	src := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for x := 0; x < 128; x++ {
+		for y := 0; y < 128; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var bmpBuf bytes.Buffer
+	if err := bmp.Encode(&bmpBuf, src); err != nil {
+		t.Fatalf("bmp.Encode: %v", err)
+	}
+
+	data, contentType, err := preprocessImage(bmpBuf.Bytes())
+	if err != nil {
+		t.Fatalf("preprocessImage(bmp): %v", err)
+	}
+	if contentType != "image/jpeg" {
+		t.Fatalf("contentType = %q, want image/jpeg", contentType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > 96*96 {
+		t.Fatalf("output not downscaled: %+v, %v", cfg, err)
+	}
+	if got := imageFilename("photo.bmp", contentType); got != "photo.jpg" {
+		t.Fatalf("imageFilename = %q, want photo.jpg", got)
+	}
+
+	if _, _, err := preprocessImage([]byte("<html>not an image</html>")); err == nil {
+		t.Fatalf("preprocessImage(html) should fail")
+	}
+
+	// 超过像素上限 2 倍的图片不解码
+	var bigBuf bytes.Buffer
+	if err := bmp.Encode(&bigBuf, image.NewRGBA(image.Rect(0, 0, 256, 256))); err != nil {
+		t.Fatalf("bmp.Encode: %v", err)
+	}
+	if _, _, err := preprocessImage(bigBuf.Bytes()); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("preprocessImage(256x256) err = %v, want too large", err)
+	}
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 单次请求最多生成的图片数
+const maxImagesPerRequest = 4
+
+// GeneratedImage 上游生成的图片
+type GeneratedImage struct {
+	URL string `json:"url"`
+	Alt string `json:"alt,omitempty"`
+}
+
+// ImageGenerationRequest OpenAI /v1/images/generations 请求
+type ImageGenerationRequest struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model,omitempty"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"` // url / b64_json
+}
+
+type ImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+type ImagesResponse struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+}
+
+var (
+	markdownImagePattern = regexp.MustCompile(`!\[([^\]]*)\]\((https?://[^)\s]+)\)`)
+	jsonImageURLPattern  = regexp.MustCompile(`"(?:url|image_url|image)"\s*:\s*"(https?://[^"]+)"`)
+)
+
+// IsImageGenerationPayload 判断是否为图片生成工具的 glm_block
+func IsImageGenerationPayload(editContent string) bool {
+	return strings.Contains(editContent, "<glm_block") &&
+		(strings.Contains(editContent, `"image_generation"`) || strings.Contains(editContent, `"generate_image"`))
+}
+
+// ParseGeneratedImages 提取 image_generation 工具 glm_block 结果中的图片 URL，按出现顺序去重
+// 正文中的 markdown 图片可能只是模型复述的链接，不算生成的图片
+func ParseGeneratedImages(content string) []GeneratedImage {
+	var images []GeneratedImage
+	seen := make(map[string]bool)
+	blocks := strings.Split(content, "<glm_block")
+	for _, block := range blocks[1:] {
+		block = "<glm_block" + block
+		if !IsImageGenerationPayload(block) {
+			continue
+		}
+		// glm_block 中的 JSON 可能把 / 转义为 \/
+		block = strings.ReplaceAll(block, `\/`, `/`)
+		for _, m := range jsonImageURLPattern.FindAllStringSubmatch(block, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				images = append(images, GeneratedImage{URL: m[1]})
+			}
+		}
+	}
+	return images
+}
+
+// FormatGeneratedImages 把生成的图片转为 markdown，跳过 seen 中已输出的
+func FormatGeneratedImages(images []GeneratedImage, seen map[string]bool) string {
+	var sb strings.Builder
+	for _, img := range images {
+		if seen[img.URL] {
+			continue
+		}
+		seen[img.URL] = true
+		alt := img.Alt
+		if alt == "" {
+			alt = "image"
+		}
+		sb.WriteString(fmt.Sprintf("\n![%s](%s)\n", escapeMarkdownTitle(alt), img.URL))
+	}
+	return sb.String()
+}
+
+// collectGeneratedImages 读取上游流，返回生成的图片和模型的文字回复
+func collectGeneratedImages(body io.Reader) ([]GeneratedImage, string) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 1024*1024), 8*1024*1024)
+
+	var images []GeneratedImage
+	seen := make(map[string]bool)
+	var answer strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			break
+		}
+
+		var upstream UpstreamData
+		if err := json.Unmarshal([]byte(payload), &upstream); err != nil {
+			continue
+		}
+		if upstream.Data.Phase == "done" {
+			break
+		}
+		if upstream.Data.Phase == "thinking" {
+			continue
+		}
+
+		if upstream.Data.Phase == "answer" && upstream.Data.DeltaContent != "" {
+			answer.WriteString(upstream.Data.DeltaContent)
+		}
+		// 每个事件只解析本次的 edit_content
+		if editContent := upstream.GetEditContent(); IsImageGenerationPayload(editContent) {
+			for _, img := range ParseGeneratedImages(editContent) {
+				if !seen[img.URL] {
+					seen[img.URL] = true
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		LogError("[Images] scanner error: %v", err)
+	}
+
+	text := strings.TrimSpace(markdownImagePattern.ReplaceAllString(answer.String(), ""))
+	return images, text
+}
+
+// HandleImageGenerations 处理 POST /v1/images/generations，通过 z.ai 的 image_generation 功能生成图片
+func HandleImageGenerations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := requestToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req ImageGenerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	}
+	if req.N <= 0 {
+		req.N = 1
+	}
+	if req.N > maxImagesPerRequest {
+		http.Error(w, fmt.Sprintf("n must be between 1 and %d", maxImagesPerRequest), http.StatusBadRequest)
+		return
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = "url"
+	}
+	if req.ResponseFormat != "url" && req.ResponseFormat != "b64_json" {
+		http.Error(w, "response_format must be url or b64_json", http.StatusBadRequest)
+		return
+	}
+	if req.Model == "" {
+		req.Model = "GLM-4.6"
+	}
+
+	prompt := req.Prompt
+	if req.Size != "" {
+		prompt += fmt.Sprintf("\n\nImage size: %s", req.Size)
+	}
+
+	chatReq := ChatRequest{
+		Model:           req.Model,
+		Messages:        []Message{{Role: "user", Content: prompt}},
+		ImageGeneration: true,
+	}
+	ApplyModelAliases(&chatReq)
+	resolvedModel, err := ResolveRequestModel(chatReq.Model, chatReq.Messages, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chatReq.Model = resolvedModel
+
+	var images []GeneratedImage
+	revisedPrompt := ""
+	// 上游每次通常只生成一张，不够时重复请求
+	for attempt := 0; attempt < req.N && len(images) < req.N; attempt++ {
+		resp, _, _, err := requestWithFallback(token, chatReq)
+		if err != nil {
+			LogError("Image generation failed: %v", err)
+			var statusErr *UpstreamStatusError
+			if errors.As(err, &statusErr) {
+				http.Error(w, "Upstream error", statusErr.StatusCode)
+				return
+			}
+			http.Error(w, "Upstream error", http.StatusBadGateway)
+			return
+		}
+		generated, text := collectGeneratedImages(resp.Body)
+		resp.Body.Close()
+		if len(generated) == 0 {
+			LogWarn("[Images] upstream returned no image: %s", text[:min(200, len(text))])
+			break
+		}
+		images = append(images, generated...)
+		if revisedPrompt == "" {
+			revisedPrompt = text
+		}
+	}
+
+	if len(images) == 0 {
+		http.Error(w, "Upstream returned no image", http.StatusBadGateway)
+		return
+	}
+	if len(images) > req.N {
+		images = images[:req.N]
+	}
+
+	result := ImagesResponse{Created: time.Now().Unix(), Data: make([]ImageData, 0, len(images))}
+	for _, img := range images {
+		item := ImageData{RevisedPrompt: revisedPrompt}
+		if req.ResponseFormat == "b64_json" {
+			data, _, err := FetchRemote(img.URL, 0)
+			if err != nil {
+				LogError("Failed to download generated image %s: %v", img.URL, err)
+				http.Error(w, "Failed to download generated image", http.StatusBadGateway)
+				return
+			}
+			item.B64JSON = base64.StdEncoding.EncodeToString(data)
+		} else {
+			item.URL = img.URL
+		}
+		result.Data = append(result.Data, item)
+	}
+
+	writeJSON(w, result)
+}
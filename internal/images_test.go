@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseGeneratedImages(t *testing.T) {
+	block := `Here you go<glm_block view="">{"type":"mcp","data":{"metadata":{"name":"image_generation","result":[{"url":"https:\/\/cdn.z.ai\/gen\/a.png"},{"image_url":"https://cdn.z.ai/gen/b.png"}]}}}</glm_block>`
+	images := ParseGeneratedImages(block)
+	if len(images) != 2 || images[0].URL != "https://cdn.z.ai/gen/a.png" || images[1].URL != "https://cdn.z.ai/gen/b.png" {
+		t.Fatalf("ParseGeneratedImages(block) = %+v", images)
+	}
+
+	// 其他工具的 glm_block 中的 URL 不算生成的图片
+	other := `<glm_block view="">{"type":"mcp","data":{"metadata":{"name":"search","result":[{"url":"https://example.com"}]}}}</glm_block>`
+	if images := ParseGeneratedImages(other); len(images) != 0 {
+		t.Fatalf("ParseGeneratedImages(other tool) = %+v, want none", images)
+	}
+
+	seen := map[string]bool{}
+	if got := FormatGeneratedImages(images, seen); got != "\n![image](https://cdn.z.ai/gen/a.png)\n\n![image](https://cdn.z.ai/gen/b.png)\n" {
+		t.Fatalf("FormatGeneratedImages = %q", got)
+	}
+	if got := FormatGeneratedImages(images, seen); got != "" {
+		t.Fatalf("already emitted images should be skipped, got %q", got)
+	}
+
+	// 正文中复述的 markdown 图片不算生成的图片
+	if images := ParseGeneratedImages("See ![logo](https://example.com/logo.png)"); len(images) != 0 {
+		t.Fatalf("ParseGeneratedImages(markdown) = %+v, want none", images)
+	}
+
+	genBlock, _ := json.Marshal(`<glm_block view="">{"type":"mcp","data":{"metadata":{"name":"image_generation","result":[{"url":"https://cdn.z.ai/gen/cat.png"}]}}}</glm_block>`)
+	stream := strings.Join([]string{
+		`data: {"data":{"phase":"thinking","delta_content":"> planning"}}`,
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(genBlock) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"A cat: ![cat](https://cdn.z.ai/gen/cat.png)"}}`,
+		`data: {"data":{"phase":"answer","delta_content":" like ![this](https://example.com/old.png) enjoy"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	got, text := collectGeneratedImages(strings.NewReader(stream))
+	if len(got) != 1 || got[0].URL != "https://cdn.z.ai/gen/cat.png" || text != "A cat:  like  enjoy" {
+		t.Fatalf("collectGeneratedImages = (%+v, %q)", got, text)
+	}
+}
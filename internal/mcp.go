@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mcpServerInfo 已知的 z.ai MCP 服务
+type mcpServerInfo struct {
+	Vision bool // 只能用于视觉模型
+}
+
+// mcpCatalogue 可以通过请求或配置开启的 z.ai MCP 服务
+var mcpCatalogue = map[string]mcpServerInfo{
+	"vlm-image-search":      {Vision: true},
+	"vlm-image-recognition": {Vision: true},
+	"vlm-image-processing":  {Vision: true},
+}
+
+func mcpCatalogueNames() []string {
+	names := make([]string, 0, len(mcpCatalogue))
+	for name := range mcpCatalogue {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// knownMCPServers 去掉目录外的服务名，用于配置中的默认值
+func knownMCPServers(model string, names []string) []string {
+	known := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := mcpCatalogue[name]; !ok {
+			LogWarn("Model override %s: unknown MCP server %q ignored", model, name)
+			continue
+		}
+		known = append(known, name)
+	}
+	return known
+}
+
+// ValidateMCPServers 校验请求的 mcp_servers：必须在目录中，视觉类服务只能用于视觉模型
+func ValidateMCPServers(model string, names []string) error {
+	baseModel, _, _ := ParseModelName(model)
+	spec, specOK := LookupModel(baseModel)
+	for _, name := range names {
+		info, ok := mcpCatalogue[name]
+		if !ok {
+			return fmt.Errorf("unknown MCP server %q, available: %s", name, strings.Join(mcpCatalogueNames(), ", "))
+		}
+		if info.Vision && specOK && !spec.Capabilities.Vision {
+			return &CapabilityError{Model: model, Reason: fmt.Sprintf("does not support MCP server %s (vision only)", name)}
+		}
+	}
+	return nil
+}
+
+// resolveMCPServers 请求指定了 mcp_servers（包括空列表）时使用请求的，否则使用模型默认值
+// 降级到不支持视觉的模型时去掉视觉类服务
+func resolveMCPServers(spec ModelSpec, requested []string) []string {
+	if requested == nil {
+		return spec.Capabilities.MCPServers
+	}
+	servers := make([]string, 0, len(requested))
+	for _, name := range requested {
+		if mcpCatalogue[name].Vision && !spec.Capabilities.Vision {
+			LogInfo("[MCP] %s does not support %s, ignored", spec.Name, name)
+			continue
+		}
+		servers = append(servers, name)
+	}
+	return servers
+}
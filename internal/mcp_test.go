@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMCPServerSelection(t *testing.T) {
+	vision := ModelSpec{Name: "GLM-4.6-V", Capabilities: ModelCapabilities{Vision: true, MCPServers: vlmMCPServers}}
+	text := ModelSpec{Name: "GLM-4.7"}
+
+	if got := resolveMCPServers(vision, nil); !reflect.DeepEqual(got, vlmMCPServers) {
+		t.Fatalf("default servers = %v", got)
+	}
+	if got := resolveMCPServers(vision, []string{}); got == nil || len(got) != 0 {
+		t.Fatalf("explicit empty list = %#v, want empty non-nil", got)
+	}
+	if got := resolveMCPServers(text, []string{"vlm-image-recognition"}); len(got) != 0 {
+		t.Fatalf("vision server kept for text model: %v", got)
+	}
+
+	if err := ValidateMCPServers("GLM-4.6-V", []string{"vlm-image-recognition"}); err != nil {
+		t.Fatalf("ValidateMCPServers(GLM-4.6-V) = %v", err)
+	}
+	if err := ValidateMCPServers("GLM-4.6-V", []string{"web-browser"}); err == nil || !strings.Contains(err.Error(), "unknown MCP server") {
+		t.Fatalf("ValidateMCPServers(unknown) = %v", err)
+	}
+	var capErr *CapabilityError
+	if err := ValidateMCPServers("GLM-4.7", []string{"vlm-image-search"}); !errors.As(err, &capErr) {
+		t.Fatalf("ValidateMCPServers(GLM-4.7, vlm-image-search) = %v, want CapabilityError", err)
+	}
+
+	servers := []string{"vlm-image-recognition", "bogus"}
+	models := buildModelRegistry([]ModelSpec{vision}, nil, map[string]ModelOverride{"GLM-4.6-V": {MCPServers: &servers}})
+	if got := models[0].Capabilities.MCPServers; !reflect.DeepEqual(got, []string{"vlm-image-recognition"}) {
+		t.Fatalf("override servers = %v", got)
+	}
+}
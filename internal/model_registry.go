@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ModelCapabilities 模型能力
+type ModelCapabilities struct {
+	Vision        bool     `json:"vision"`
+	Video         bool     `json:"video"`
+	Thinking      bool     `json:"thinking"`
+	Search        bool     `json:"search"`
+	Tools         bool     `json:"tools"`
+	Documents     bool     `json:"documents"`
+	ContextLength int      `json:"context_length,omitempty"`
+	MCPServers    []string `json:"mcp_servers,omitempty"`
+}
+
+// ModelSpec 基础模型描述（不包含标签后缀）
+type ModelSpec struct {
+	Name         string            `json:"name"`
+	UpstreamID   string            `json:"upstream_id"`
+	Capabilities ModelCapabilities `json:"capabilities"`
+	Hidden       bool              `json:"hidden,omitempty"`
+	Created      int64             `json:"created,omitempty"`
+}
+
+var vlmMCPServers = []string{"vlm-image-search", "vlm-image-recognition", "vlm-image-processing"}
+
+// 内置模型表，上游模型列表不可用时使用
+var builtinModels = []ModelSpec{
+	{Name: "GLM-5", UpstreamID: "glm-5", Capabilities: ModelCapabilities{
+		Thinking: true, Search: true, Tools: true, Documents: true, ContextLength: 200000,
+	}},
+	{Name: "GLM-4.5", UpstreamID: "0727-360B-API", Capabilities: ModelCapabilities{
+		Tools: true, Documents: true, ContextLength: 128000,
+	}},
+	{Name: "GLM-4.6", UpstreamID: "GLM-4-6-API-V1", Capabilities: ModelCapabilities{
+		Tools: true, Documents: true, ContextLength: 200000,
+	}},
+	{Name: "GLM-4.7", UpstreamID: "glm-4.7", Capabilities: ModelCapabilities{
+		Thinking: true, Search: true, Tools: true, Documents: true, ContextLength: 200000,
+	}},
+	{Name: "GLM-4.5-V", UpstreamID: "glm-4.5v", Capabilities: ModelCapabilities{
+		Vision: true, Video: true, Tools: true, Documents: true, ContextLength: 64000,
+	}},
+	{Name: "GLM-4.6-V", UpstreamID: "glm-4.6v", Capabilities: ModelCapabilities{
+		Vision: true, Video: true, Thinking: true, Tools: true, Documents: true, ContextLength: 128000, MCPServers: vlmMCPServers,
+	}},
+	{Name: "GLM-4.5-Air", UpstreamID: "0727-106B-API", Capabilities: ModelCapabilities{
+		Tools: true, Documents: true, ContextLength: 128000,
+	}},
+	{Name: "0808-360B-DR", UpstreamID: "0808-360B-DR", Hidden: true, Capabilities: ModelCapabilities{
+		Tools: true,
+	}},
+}
+
+// DiscoveredModel 上游模型列表中的模型，上游未声明的能力为 nil，合并时保留内置值
+type DiscoveredModel struct {
+	Name       string
+	UpstreamID string
+	Created    int64
+	Vision     *bool
+	Video      *bool
+	Thinking   *bool
+	Search     *bool
+	Documents  *bool
+}
+
+// 内置模型的 created 使用固定值，避免每次重启变化（2025-07-28）
+const builtinModelsCreated int64 = 1753660800
+
+var (
+	discoveredModels []DiscoveredModel
+	modelRegistry    []ModelSpec
+	registryLock     sync.RWMutex
+)
+
+// SetDiscoveredModels 更新上游发现的模型并重建注册表
+func SetDiscoveredModels(models []DiscoveredModel) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	discoveredModels = models
+	modelRegistry = buildModelRegistry(builtinModels, discoveredModels, modelOverrides())
+}
+
+func currentModels() []ModelSpec {
+	registryLock.RLock()
+	models := modelRegistry
+	registryLock.RUnlock()
+	if models != nil {
+		return models
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if modelRegistry == nil {
+		modelRegistry = buildModelRegistry(builtinModels, discoveredModels, modelOverrides())
+	}
+	return modelRegistry
+}
+
+func modelOverrides() map[string]ModelOverride {
+	if Cfg == nil {
+		return nil
+	}
+	return Cfg.Models.Overrides
+}
+
+// buildModelRegistry 合并内置模型、上游发现的模型和配置覆盖
+// 上游声明的能力优先于内置值，未声明的保留内置值，配置覆盖优先于两者
+func buildModelRegistry(builtin []ModelSpec, discovered []DiscoveredModel, overrides map[string]ModelOverride) []ModelSpec {
+	models := make([]ModelSpec, 0, len(builtin)+len(discovered))
+	models = append(models, builtin...)
+
+	for _, d := range discovered {
+		idx := findModelIndex(models, d.UpstreamID)
+		if idx == -1 {
+			idx = findModelIndex(models, d.Name)
+		}
+		if idx == -1 {
+			models = append(models, ModelSpec{
+				Name:         d.Name,
+				UpstreamID:   d.UpstreamID,
+				Created:      d.Created,
+				Capabilities: ModelCapabilities{Tools: true},
+			})
+			idx = len(models) - 1
+		}
+		// 上下文长度、MCP 等上游不返回的能力保留内置值
+		m := &models[idx]
+		m.UpstreamID = d.UpstreamID
+		applyCapability(&m.Capabilities.Vision, d.Vision)
+		applyCapability(&m.Capabilities.Video, d.Video)
+		applyCapability(&m.Capabilities.Thinking, d.Thinking)
+		applyCapability(&m.Capabilities.Search, d.Search)
+		applyCapability(&m.Capabilities.Documents, d.Documents)
+		// 失去视觉能力时视频能力一并关闭
+		m.Capabilities.Video = m.Capabilities.Video && m.Capabilities.Vision
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		o := overrides[name]
+		idx := findModelIndex(models, name)
+		if idx == -1 {
+			if o.UpstreamID == "" {
+				LogWarn("Model override %s ignored: unknown model without upstream_id", name)
+				continue
+			}
+			models = append(models, ModelSpec{
+				Name:         name,
+				UpstreamID:   o.UpstreamID,
+				Capabilities: ModelCapabilities{Tools: true},
+			})
+			idx = len(models) - 1
+		}
+
+		m := &models[idx]
+		if o.UpstreamID != "" {
+			m.UpstreamID = o.UpstreamID
+		}
+		applyCapability(&m.Capabilities.Vision, o.Vision)
+		applyCapability(&m.Capabilities.Video, o.Video)
+		applyCapability(&m.Capabilities.Thinking, o.Thinking)
+		applyCapability(&m.Capabilities.Search, o.Search)
+		applyCapability(&m.Capabilities.Tools, o.Tools)
+		applyCapability(&m.Capabilities.Documents, o.Documents)
+		if o.ContextLength > 0 {
+			m.Capabilities.ContextLength = o.ContextLength
+		}
+		if o.MCPServers != nil {
+			m.Capabilities.MCPServers = knownMCPServers(name, *o.MCPServers)
+		}
+		m.Hidden = m.Hidden || o.Hidden
+	}
+
+	for i := range models {
+		if models[i].Created == 0 {
+			models[i].Created = builtinModelsCreated
+		}
+	}
+
+	return models
+}
+
+// applyCapability 只在 v 有值时覆盖
+func applyCapability(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// findModelIndex 按展示名或上游 ID 查找（忽略大小写）
+func findModelIndex(models []ModelSpec, key string) int {
+	if key == "" {
+		return -1
+	}
+	for i, m := range models {
+		if m.Name == key {
+			return i
+		}
+	}
+	for i, m := range models {
+		if strings.EqualFold(m.Name, key) || strings.EqualFold(m.UpstreamID, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// LookupModel 根据基础模型名查找模型描述
+func LookupModel(baseModel string) (ModelSpec, bool) {
+	models := currentModels()
+	if idx := findModelIndex(models, baseModel); idx != -1 {
+		return models[idx], true
+	}
+	return ModelSpec{}, false
+}
+
+// ListModelIDs 返回 v1/models 展示的模型 ID（按能力展开标签组合）
+func ListModelIDs() []string {
+	var ids []string
+	for _, m := range currentModels() {
+		if m.Hidden {
+			continue
+		}
+		ids = append(ids, m.Name)
+		if m.Capabilities.Thinking {
+			ids = append(ids, m.Name+"-thinking")
+		}
+		if m.Capabilities.Search {
+			ids = append(ids, m.Name+"-search")
+		}
+		if m.Capabilities.Thinking && m.Capabilities.Search {
+			ids = append(ids, m.Name+"-thinking-search")
+		}
+	}
+	return append(ids, listVirtualModelNames()...)
+}
+
+// BuildModelInfo 根据模型 ID（可带标签、可为别名或虚拟模型）生成 v1/models 的模型描述
+// 以上游 ID 或大小写不同的写法查询时，id 为规范名，alias 为查询时使用的写法
+// 基础模型未知或标签不被支持时返回 false
+func BuildModelInfo(id string) (ModelInfo, bool) {
+	if resolved := resolveModelName(id); resolved != id {
+		info, ok := buildModelInfo(resolved)
+		info.ID = id
+		info.Alias = ""
+		return info, ok
+	}
+	info, ok := buildModelInfo(id)
+	if ok && info.ID != id {
+		info.Alias = id
+	}
+	return info, ok
+}
+
+func buildModelInfo(id string) (ModelInfo, bool) {
+	baseModel, enableThinking, enableSearch := ParseModelName(id)
+	spec, ok := LookupModel(baseModel)
+	if !ok {
+		return ModelInfo{}, false
+	}
+	caps := spec.Capabilities
+	if (enableThinking && !caps.Thinking) || (enableSearch && !caps.Search) {
+		return ModelInfo{}, false
+	}
+
+	inputModalities := []string{"text"}
+	if caps.Vision {
+		inputModalities = append(inputModalities, "image")
+	}
+	if caps.Video {
+		inputModalities = append(inputModalities, "video")
+	}
+	if caps.Documents {
+		inputModalities = append(inputModalities, "file")
+	}
+	supportedTags := []string{}
+	if caps.Thinking {
+		supportedTags = append(supportedTags, "thinking")
+	}
+	if caps.Search {
+		supportedTags = append(supportedTags, "search")
+	}
+
+	return ModelInfo{
+		ID:               FormatModelName(spec.Name, enableThinking, enableSearch),
+		Object:           "model",
+		Created:          spec.Created,
+		OwnedBy:          "z.ai",
+		Root:             spec.Name,
+		ContextLength:    caps.ContextLength,
+		InputModalities:  inputModalities,
+		OutputModalities: []string{"text"},
+		SupportedTags:    supportedTags,
+		Thinking:         enableThinking,
+		Search:           enableSearch,
+		SupportsTools:    caps.Tools,
+	}, true
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildModelRegistryMergesDiscoveredAndOverrides(t *testing.T) {
+	builtin := []ModelSpec{
+		{Name: "GLM-4.7", UpstreamID: "glm-4.7", Capabilities: ModelCapabilities{Thinking: true}},
+		{Name: "GLM-4.6-V", UpstreamID: "glm-4.6v", Capabilities: ModelCapabilities{Vision: true, Documents: true}},
+	}
+	enabled, disabled := true, false
+	discovered := []DiscoveredModel{
+		{Name: "GLM-4.7", UpstreamID: "glm-4.7", Search: &enabled},
+		{Name: "GLM-4.6-V", UpstreamID: "glm-4.6v"},
+		{Name: "GLM-6", UpstreamID: "glm-6", Vision: &enabled},
+	}
+	overrides := map[string]ModelOverride{
+		"GLM-6":   {Vision: &disabled},
+		"GLM-Old": {Hidden: true},
+	}
+
+	models := buildModelRegistry(builtin, discovered, overrides)
+	if len(models) != 3 {
+		t.Fatalf("len(models) = %d, want 3", len(models))
+	}
+	if !models[0].Capabilities.Search || !models[0].Capabilities.Thinking {
+		t.Fatalf("GLM-4.7 capabilities not merged: %+v", models[0])
+	}
+	if !models[1].Capabilities.Vision || !models[1].Capabilities.Documents {
+		t.Fatalf("GLM-4.6-V lost builtin capabilities missing upstream: %+v", models[1])
+	}
+	if models[2].Name != "GLM-6" || models[2].UpstreamID != "glm-6" || models[2].Capabilities.Vision {
+		t.Fatalf("GLM-6 override not applied: %+v", models[2])
+	}
+	for _, m := range models {
+		if m.Created != builtinModelsCreated {
+			t.Fatalf("%s created = %d, want %d", m.Name, m.Created, builtinModelsCreated)
+		}
+	}
+}
+
+func TestBuildModelInfo(t *testing.T) {
+	info, ok := BuildModelInfo("GLM-4.6-V-thinking")
+	if !ok {
+		t.Fatalf("BuildModelInfo(GLM-4.6-V-thinking) not found")
+	}
+	if info.Root != "GLM-4.6-V" || !info.Thinking || info.Search {
+		t.Fatalf("unexpected model info: %+v", info)
+	}
+	if strings.Join(info.InputModalities, ",") != "text,image,video,file" {
+		t.Fatalf("InputModalities = %v, want [text image video file]", info.InputModalities)
+	}
+
+	info, ok = BuildModelInfo("glm-4.6v-thinking")
+	if !ok || info.ID != "GLM-4.6-V-thinking" || info.Alias != "glm-4.6v-thinking" {
+		t.Fatalf("BuildModelInfo(glm-4.6v-thinking) = %+v, %v", info, ok)
+	}
+	if info, _ := BuildModelInfo("GLM-4.6-V"); info.Alias != "" {
+		t.Fatalf("canonical id has alias %q", info.Alias)
+	}
+
+	if _, ok := BuildModelInfo("GLM-4.6-V-search"); ok {
+		t.Fatalf("BuildModelInfo(GLM-4.6-V-search) should be rejected")
+	}
+	if _, ok := BuildModelInfo("gpt-unknown"); ok {
+		t.Fatalf("BuildModelInfo(gpt-unknown) should be rejected")
+	}
+}
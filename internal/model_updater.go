@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// upstreamModelsResponse z.ai /api/models 响应（仅取用到的字段）
+type upstreamModelsResponse struct {
+	Data []upstreamModel `json:"data"`
+}
+
+type upstreamModel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Created int64  `json:"created"`
+	Info    struct {
+		IsActive *bool `json:"is_active"`
+		Meta     struct {
+			Capabilities map[string]interface{} `json:"capabilities"`
+		} `json:"meta"`
+	} `json:"info"`
+}
+
+var visionSuffixPattern = regexp.MustCompile(`(\d)[vV]$`)
+
+func fetchUpstreamModels() {
+	token, err := GetAnonymousToken()
+	if err != nil {
+		LogError("Failed to fetch models: anonymous token: %v", err)
+		return
+	}
+
+	req, err := http.NewRequest("GET", "https://chat.z.ai/api/models", nil)
+	if err != nil {
+		LogError("Failed to create models request: %v", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-FE-Version", GetFeVersion())
+	req.Header.Set("Origin", "https://chat.z.ai")
+	req.Header.Set("Referer", "https://chat.z.ai/")
+
+	client := GetProxyClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		LogError("Failed to fetch models: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		LogError("Failed to fetch models: status %d", resp.StatusCode)
+		return
+	}
+
+	var modelsResp upstreamModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
+		LogError("Failed to parse models response: %v", err)
+		return
+	}
+
+	models := convertUpstreamModels(modelsResp.Data)
+	if len(models) == 0 {
+		LogWarn("Upstream returned no models, keeping current list")
+		return
+	}
+
+	SetDiscoveredModels(models)
+	LogInfo("Updated models: %d discovered", len(models))
+}
+
+func convertUpstreamModels(upstream []upstreamModel) []DiscoveredModel {
+	var models []DiscoveredModel
+	for _, m := range upstream {
+		if m.ID == "" {
+			continue
+		}
+		if m.Info.IsActive != nil && !*m.Info.IsActive {
+			continue
+		}
+		caps := m.Info.Meta.Capabilities
+		models = append(models, DiscoveredModel{
+			Name:       deriveDisplayName(m.ID, m.Name),
+			UpstreamID: m.ID,
+			Created:    m.Created,
+			Vision:     capabilityFlag(caps, "vision"),
+			Video:      capabilityFlag(caps, "video"),
+			Thinking:   capabilityFlag(caps, "think"),
+			Search:     capabilityFlag(caps, "web_search"),
+			Documents:  capabilityFlag(caps, "file_qa"),
+		})
+	}
+	return models
+}
+
+// capabilityFlag 上游未声明或不是布尔值时返回 nil
+func capabilityFlag(caps map[string]interface{}, key string) *bool {
+	v, ok := caps[key].(bool)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// deriveDisplayName 生成展示名：优先使用上游名称，规范化为 GLM-4.6-V 这种形式
+func deriveDisplayName(id, name string) string {
+	display := strings.TrimSpace(name)
+	if display == "" {
+		display = id
+	}
+	display = strings.Join(strings.Fields(display), "-")
+	if strings.HasPrefix(strings.ToLower(display), "glm-") {
+		display = "GLM-" + display[len("glm-"):]
+		display = visionSuffixPattern.ReplaceAllString(display, "$1-V")
+	}
+	// 避免与标签后缀冲突
+	display, _, _ = ParseModelName(display)
+	return display
+}
+
+func StartModelUpdater() {
+	if !Cfg.ModelDiscovery {
+		LogInfo("Model discovery disabled, using built-in model list")
+		return
+	}
+
+	fetchUpstreamModels()
+
+	ticker := time.NewTicker(Cfg.ModelRefreshInterval)
+	go func() {
+		for range ticker.C {
+			fetchUpstreamModels()
+		}
+	}()
+}
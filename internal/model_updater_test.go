@@ -0,0 +1,20 @@
+package internal
+
+import "testing"
+
+func TestDeriveDisplayName(t *testing.T) {
+	tests := []struct {
+		id, name, want string
+	}{
+		{id: "glm-4.6v", name: "GLM-4.6V", want: "GLM-4.6-V"},
+		{id: "glm-5", name: "", want: "GLM-5"},
+		{id: "GLM-4-6-API-V1", name: "GLM-4.6", want: "GLM-4.6"},
+		{id: "new-model", name: "New Model", want: "New-Model"},
+	}
+
+	for _, tt := range tests {
+		if got := deriveDisplayName(tt.id, tt.name); got != tt.want {
+			t.Fatalf("deriveDisplayName(%q, %q) = %q, want %q", tt.id, tt.name, got, tt.want)
+		}
+	}
+}
@@ -7,36 +7,6 @@ import (
 	"strings"
 )
 
-// 基础模型映射（不包含标签后缀）
-var BaseModelMapping = map[string]string{
-	"GLM-5":        "glm-5",
-	"GLM-4.5":      "0727-360B-API",
-	"GLM-4.6":      "GLM-4-6-API-V1",
-	"GLM-4.7":      "glm-4.7",
-	"GLM-4.5-V":    "glm-4.5v",
-	"GLM-4.6-V":    "glm-4.6v",
-	"GLM-4.5-Air":  "0727-106B-API",
-	"0808-360B-DR": "0808-360B-DR",
-}
-
-// v1/models 返回的模型列表（不包含所有标签组合）
-var ModelList = []string{
-	"GLM-5",
-	"GLM-5-thinking",
-	"GLM-5-search",
-	"GLM-5-thinking-search",
-	"GLM-4.5",
-	"GLM-4.6",
-	"GLM-4.7",
-	"GLM-4.7-thinking",
-	"GLM-4.7-thinking-search",
-	"GLM-4.5-V",
-	"GLM-4.6-V",
-	"GLM-4.6-V-thinking",
-	"GLM-4.5-Air",
-	// "0808-360B-DR",
-}
-
 // 解析模型名称，提取基础模型名和标签
 // 支持 -thinking 和 -search 标签的任意排列组合
 func ParseModelName(model string) (baseModel string, enableThinking bool, enableSearch bool) {
@@ -60,6 +30,18 @@ func ParseModelName(model string) (baseModel string, enableThinking bool, enable
 	return baseModel, enableThinking, enableSearch
 }
 
+// FormatModelName 与 ParseModelName 相反，拼接基础模型名和标签
+func FormatModelName(baseModel string, enableThinking bool, enableSearch bool) string {
+	model := baseModel
+	if enableThinking {
+		model += "-thinking"
+	}
+	if enableSearch {
+		model += "-search"
+	}
+	return model
+}
+
 func IsThinkingModel(model string) bool {
 	_, enableThinking, _ := ParseModelName(model)
 	return enableThinking
@@ -71,9 +53,9 @@ func IsSearchModel(model string) bool {
 }
 
 func GetTargetModel(model string) string {
-	baseModel, _, _ := ParseModelName(model)
-	if target, ok := BaseModelMapping[baseModel]; ok {
-		return target
+	baseModel, _, _ := ParseModelName(resolveModelName(model))
+	if spec, ok := LookupModel(baseModel); ok {
+		return spec.UpstreamID
 	}
 	return baseModel
 }
@@ -86,7 +68,15 @@ type ContentPart struct {
 }
 
 type ImageURL struct {
-	URL string `json:"url"`
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"` // auto / low / high
+}
+
+// FileInput OpenAI file 内容项：base64 file_data 或已上传的 file_id
+type FileInput struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
 }
 
 // Message 支持纯文本和多模态内容
@@ -153,7 +143,64 @@ func (m *Message) ParseContent() (text string, imageURLs []string) {
 	return text, imageURLs
 }
 
+// ParseVideoURLs 解析 type=video_url 的内容项，返回视频 URL 或 data URL
+func (m *Message) ParseVideoURLs() []string {
+	content, ok := m.Content.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var videoURLs []string
+	for _, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if partType, _ := part["type"].(string); partType != "video_url" {
+			continue
+		}
+		if videoURL, ok := part["video_url"].(map[string]interface{}); ok {
+			if url, ok := videoURL["url"].(string); ok && url != "" {
+				videoURLs = append(videoURLs, url)
+			}
+		}
+	}
+	return videoURLs
+}
+
+// ParseFileParts 解析 type=file 的内容项
+func (m *Message) ParseFileParts() []FileInput {
+	content, ok := m.Content.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var files []FileInput
+	for _, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if partType, _ := part["type"].(string); partType != "file" {
+			continue
+		}
+		fileObj, ok := part["file"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		input := FileInput{}
+		input.FileID, _ = fileObj["file_id"].(string)
+		input.FileData, _ = fileObj["file_data"].(string)
+		input.Filename, _ = fileObj["filename"].(string)
+		if input.FileID != "" || input.FileData != "" {
+			files = append(files, input)
+		}
+	}
+	return files
+}
+
 // 转换为上游消息格式，支持多模态
+// 文本与图片按原顺序输出，相邻文本合并；图片保留 detail 提示
 func (m *Message) ToUpstreamMessage(urlToFileID map[string]string) map[string]interface{} {
 	text, imageURLs := m.ParseContent()
 
@@ -167,22 +214,53 @@ func (m *Message) ToUpstreamMessage(urlToFileID map[string]string) map[string]in
 
 	// 有图片，构建多模态内容
 	var content []interface{}
-	if text != "" {
+	var pendingText strings.Builder
+	flushText := func() {
+		if pendingText.Len() == 0 {
+			return
+		}
 		content = append(content, map[string]interface{}{
 			"type": "text",
-			"text": text,
+			"text": pendingText.String(),
 		})
+		pendingText.Reset()
 	}
-	for _, imgURL := range imageURLs {
-		if fileID, ok := urlToFileID[imgURL]; ok {
+
+	parts, _ := m.Content.([]interface{})
+	for _, item := range parts {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch partType, _ := part["type"].(string); partType {
+		case "text":
+			if t, ok := part["text"].(string); ok {
+				pendingText.WriteString(t)
+			}
+		case "image_url":
+			imgURL, ok := part["image_url"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			url, _ := imgURL["url"].(string)
+			fileID, ok := urlToFileID[url]
+			if !ok {
+				continue
+			}
+			flushText()
+			image := map[string]interface{}{
+				"url": fileID,
+			}
+			if detail, ok := imgURL["detail"].(string); ok && detail != "" {
+				image["detail"] = detail
+			}
 			content = append(content, map[string]interface{}{
-				"type": "image_url",
-				"image_url": map[string]interface{}{
-					"url": fileID,
-				},
+				"type":      "image_url",
+				"image_url": image,
 			})
 		}
 	}
+	flushText()
 
 	return map[string]interface{}{
 		"role":    m.Role,
@@ -191,11 +269,35 @@ func (m *Message) ToUpstreamMessage(urlToFileID map[string]string) map[string]in
 }
 
 type ChatRequest struct {
-	Model      string           `json:"model"`
-	Messages   []Message        `json:"messages"`
-	Stream     bool             `json:"stream"`
-	Tools      []ToolDefinition `json:"tools,omitempty"`
-	ToolChoice interface{}      `json:"tool_choice,omitempty"`
+	Model       string           `json:"model"`
+	Messages    []Message        `json:"messages"`
+	Stream      bool             `json:"stream"`
+	Tools       []ToolDefinition `json:"tools,omitempty"`
+	ToolChoice  interface{}      `json:"tool_choice,omitempty"`
+	Temperature *float64         `json:"temperature,omitempty"`
+	TopP        *float64         `json:"top_p,omitempty"`
+	MaxTokens   *int             `json:"max_tokens,omitempty"`
+	// 只有虚拟模型的请求才把采样参数发给上游，其余请求与之前一样发送空 params
+	sendParams bool
+
+	// 扩展参数：开启 z.ai 图片生成，生成的图片以 markdown 形式出现在回复中
+	ImageGeneration bool `json:"image_generation,omitempty"`
+	// 扩展参数：联网搜索引用的输出方式，见 CitationFormat* 常量
+	CitationFormat string `json:"citation_format,omitempty"`
+	// 联网搜索：OpenAI 的 web_search_options，或扩展参数 search（auto / force / off），优先于 -search 标签
+	WebSearchOptions *WebSearchOptions `json:"web_search_options,omitempty"`
+	Search           string            `json:"search,omitempty"`
+	// 扩展参数：图片搜索结果除 image_search_results 外，再以 markdown 图片追加到正文
+	ImageSearchMarkdown bool `json:"image_search_markdown,omitempty"`
+	// 扩展参数：返回 z.ai 自身执行的工具 / MCP 调用记录（tool_trace），用于排查回答的来源
+	ToolTrace bool `json:"tool_trace,omitempty"`
+	// 扩展参数：开启的 z.ai MCP 服务，替换模型默认值；空列表表示全部关闭
+	MCPServers []string `json:"mcp_servers,omitempty"`
+	// thinking 开关：OpenAI 的 reasoning_effort（none / low / medium / high）或 Anthropic 的 thinking，优先于 -thinking 标签
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+	Thinking        *ThinkingConfig `json:"thinking,omitempty"`
+	// 扩展参数：思考内容的输出方式，见 ReasoningFormat* 常量
+	ReasoningFormat string `json:"reasoning_format,omitempty"`
 }
 
 type ChatCompletionChunk struct {
@@ -217,13 +319,60 @@ type Delta struct {
 	Content          string          `json:"content,omitempty"`
 	ReasoningContent string          `json:"reasoning_content,omitempty"`
 	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+	Annotations      []Annotation    `json:"annotations,omitempty"`
+
+	// 扩展字段：图片搜索结果，每次搜索输出一次
+	ImageSearchResults []ImageSearchResult `json:"image_search_results,omitempty"`
+	// 扩展字段：上游工具调用记录，每个调用完成时输出一次
+	ToolTrace []ToolTraceEntry `json:"tool_trace,omitempty"`
+	// reasoning_format=reasoning 时的思考内容
+	Reasoning *ReasoningSummary `json:"reasoning,omitempty"`
 }
 
 type MessageResp struct {
-	Role             string     `json:"role"`
-	Content          *string    `json:"content"`
-	ReasoningContent string     `json:"reasoning_content,omitempty"`
-	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Role             string       `json:"role"`
+	Content          *string      `json:"content"`
+	ReasoningContent string       `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall   `json:"tool_calls,omitempty"`
+	Annotations      []Annotation `json:"annotations,omitempty"`
+
+	// 扩展字段：图片搜索结果
+	ImageSearchResults []ImageSearchResult `json:"image_search_results,omitempty"`
+	// 扩展字段：上游工具调用记录
+	ToolTrace []ToolTraceEntry `json:"tool_trace,omitempty"`
+	// reasoning_format=reasoning 时的思考内容
+	Reasoning *ReasoningSummary `json:"reasoning,omitempty"`
+}
+
+// Annotation OpenAI 格式的消息注解，偏移按字符（rune）计算
+type Annotation struct {
+	Type        string       `json:"type"`
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
+}
+
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+}
+
+// shiftAnnotations 返回偏移整体移动 delta 后的注解副本
+func shiftAnnotations(annotations []Annotation, delta int) []Annotation {
+	if len(annotations) == 0 {
+		return nil
+	}
+	shifted := make([]Annotation, 0, len(annotations))
+	for _, a := range annotations {
+		if a.URLCitation != nil {
+			citation := *a.URLCitation
+			citation.StartIndex += delta
+			citation.EndIndex += delta
+			a.URLCitation = &citation
+		}
+		shifted = append(shifted, a)
+	}
+	return shifted
 }
 
 type ChatCompletionResponse struct {
@@ -240,30 +389,63 @@ type ModelsResponse struct {
 }
 
 type ModelInfo struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	OwnedBy string `json:"owned_by"`
+	ID               string   `json:"id"`
+	Alias            string   `json:"alias,omitempty"` // 查询时使用的非规范写法
+	Object           string   `json:"object"`
+	Created          int64    `json:"created"`
+	OwnedBy          string   `json:"owned_by"`
+	Root             string   `json:"root"`
+	ContextLength    int      `json:"context_length,omitempty"`
+	InputModalities  []string `json:"input_modalities"`
+	OutputModalities []string `json:"output_modalities"`
+	SupportedTags    []string `json:"supported_tags"`
+	Thinking         bool     `json:"thinking"`
+	Search           bool     `json:"search"`
+	SupportsTools    bool     `json:"supports_tools"`
 }
 
 var searchRefPattern = regexp.MustCompile(`【turn\d+search(\d+)】`)
 var searchRefPrefixPattern = regexp.MustCompile(`【(t(u(r(n(\d+(s(e(a(r(c(h(\d+)?)?)?)?)?)?)?)?)?)?)?)?$`)
 
 type SearchResult struct {
-	Title string `json:"title"`
-	URL   string `json:"url"`
-	Index int    `json:"index"`
-	RefID string `json:"ref_id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Index   int    `json:"index"`
+	RefID   string `json:"ref_id"`
+	Snippet string `json:"snippet,omitempty"`
 }
 
 type SearchRefFilter struct {
-	buffer        string
-	searchResults map[string]SearchResult
+	buffer          string
+	reasoningBuffer string
+	searchResults   map[string]SearchResult
+	renderer        CitationRenderer
+	cited           []SearchResult // 正文中出现过的引用，按首次出现顺序；思考内容中的引用不计入
+	citedRefs       map[string]bool
+
+	// annotations 模式：正文中的引用标记去掉，改为 url_citation 注解
+	annotate     bool
+	outRunes     int // ProcessContent 已输出的字符数
+	segmentStart int // 下一个引用覆盖范围的起点：上一个换行或引用之后
+	lastRefStart int
+	lastRefEnd   int
+	hasRef       bool
 }
 
 func NewSearchRefFilter() *SearchRefFilter {
 	return &SearchRefFilter{
 		searchResults: make(map[string]SearchResult),
+		renderer:      markdownCitations{},
+		citedRefs:     make(map[string]bool),
+	}
+}
+
+// SetCitationFormat 切换引用格式，未知格式沿用 markdown
+func (f *SearchRefFilter) SetCitationFormat(format string) {
+	if renderer, ok := citationRenderers[format]; ok {
+		f.renderer = renderer
 	}
+	f.annotate = format == CitationFormatAnnotations
 }
 
 func (f *SearchRefFilter) AddSearchResults(results []SearchResult) {
@@ -279,27 +461,25 @@ func escapeMarkdownTitle(title string) string {
 	return title
 }
 
-func (f *SearchRefFilter) Process(content string) string {
-	content = f.buffer + content
-	f.buffer = ""
-
-	if content == "" {
-		return ""
-	}
-
-	content = searchRefPattern.ReplaceAllStringFunc(content, func(match string) string {
+// replaceRefs 按引用格式替换引用标记，未知的引用直接去掉；cite 为 true 时记录到脚注列表
+func (f *SearchRefFilter) replaceRefs(content string, cite bool) string {
+	return searchRefPattern.ReplaceAllStringFunc(content, func(match string) string {
 		runes := []rune(match)
 		refID := string(runes[1 : len(runes)-1])
-		if result, ok := f.searchResults[refID]; ok {
-			return fmt.Sprintf(`[\[%d\]](%s)`, result.Index, result.URL)
+		result, ok := f.searchResults[refID]
+		if !ok {
+			return ""
 		}
-		return ""
+		if cite && !f.citedRefs[refID] {
+			f.citedRefs[refID] = true
+			f.cited = append(f.cited, result)
+		}
+		return f.renderer.Ref(result)
 	})
+}
 
-	if content == "" {
-		return ""
-	}
-
+// splitRefPrefix 末尾可能是不完整的引用标记时留到下一段处理
+func splitRefPrefix(content string) (string, string) {
 	maxPrefixLen := 20
 	if len(content) < maxPrefixLen {
 		maxPrefixLen = len(content)
@@ -308,30 +488,130 @@ func (f *SearchRefFilter) Process(content string) string {
 	for i := 1; i <= maxPrefixLen; i++ {
 		suffix := content[len(content)-i:]
 		if searchRefPrefixPattern.MatchString(suffix) {
-			f.buffer = suffix
-			return content[:len(content)-i]
+			return content[:len(content)-i], suffix
 		}
 	}
+	return content, ""
+}
 
-	return content
+func (f *SearchRefFilter) Process(content string) string {
+	return f.process(&f.buffer, content, true)
 }
 
 func (f *SearchRefFilter) Flush() string {
-	result := f.buffer
-	f.buffer = ""
+	return f.flush(&f.buffer, true)
+}
+
+// ProcessReasoning 处理思考内容，使用独立的缓冲区，引用不计入正文的脚注列表
+func (f *SearchRefFilter) ProcessReasoning(content string) string {
+	return f.process(&f.reasoningBuffer, content, false)
+}
+
+func (f *SearchRefFilter) FlushReasoning() string {
+	return f.flush(&f.reasoningBuffer, false)
+}
+
+func (f *SearchRefFilter) process(buffer *string, content string, cite bool) string {
+	content = *buffer + content
+	*buffer = ""
+
+	if content == "" {
+		return ""
+	}
+
+	content = f.replaceRefs(content, cite)
+	if content == "" {
+		return ""
+	}
+
+	content, *buffer = splitRefPrefix(content)
+	return content
+}
+
+func (f *SearchRefFilter) flush(buffer *string, cite bool) string {
+	result := *buffer
+	*buffer = ""
 	if result != "" {
-		result = searchRefPattern.ReplaceAllStringFunc(result, func(match string) string {
-			runes := []rune(match)
-			refID := string(runes[1 : len(runes)-1])
-			if r, ok := f.searchResults[refID]; ok {
-				return fmt.Sprintf(`[\[%d\]](%s)`, r.Index, r.URL)
+		result = f.replaceRefs(result, cite)
+	}
+	return result
+}
+
+// ProcessContent 处理回复正文；annotations 模式下同时返回 url_citation 注解，
+// 偏移相对返回文本的起点（覆盖范围跨段时起点可能为负）
+func (f *SearchRefFilter) ProcessContent(content string) (string, []Annotation) {
+	if !f.annotate {
+		return f.Process(content), nil
+	}
+
+	content = f.buffer + content
+	f.buffer = ""
+	content, f.buffer = splitRefPrefix(content)
+	return f.annotateRefs(content)
+}
+
+// FlushContent 输出正文缓冲区中剩余的内容
+func (f *SearchRefFilter) FlushContent() (string, []Annotation) {
+	if !f.annotate {
+		return f.Flush(), nil
+	}
+
+	content := f.buffer
+	f.buffer = ""
+	return f.annotateRefs(content)
+}
+
+// annotateRefs 去掉引用标记，每个引用覆盖从上一个换行或引用之后到标记处的文本；
+// 连续的多个引用覆盖同一范围
+func (f *SearchRefFilter) annotateRefs(content string) (string, []Annotation) {
+	base := f.outRunes
+	var sb strings.Builder
+	var annotations []Annotation
+
+	writeText := func(text string) {
+		for _, r := range text {
+			f.outRunes++
+			if r == '\n' {
+				f.segmentStart = f.outRunes
 			}
-			return ""
+		}
+		sb.WriteString(text)
+	}
+
+	last := 0
+	for _, loc := range searchRefPattern.FindAllStringIndex(content, -1) {
+		writeText(content[last:loc[0]])
+		last = loc[1]
+
+		runes := []rune(content[loc[0]:loc[1]])
+		result, ok := f.searchResults[string(runes[1:len(runes)-1])]
+		if !ok {
+			continue
+		}
+
+		pos := f.outRunes
+		start := f.segmentStart
+		if f.hasRef && pos == f.lastRefEnd {
+			start = f.lastRefStart
+		}
+		annotations = append(annotations, Annotation{
+			Type: "url_citation",
+			URLCitation: &URLCitation{
+				URL:        result.URL,
+				Title:      result.Title,
+				StartIndex: start - base,
+				EndIndex:   pos - base,
+			},
 		})
+		f.lastRefStart, f.lastRefEnd, f.hasRef = start, pos, true
+		f.segmentStart = pos
 	}
-	return result
+	writeText(content[last:])
+
+	return sb.String(), annotations
 }
 
+// GetSearchResultsMarkdown 搜索结果到达时输出的来源列表，由引用格式决定
 func (f *SearchRefFilter) GetSearchResultsMarkdown() string {
 	if len(f.searchResults) == 0 {
 		return ""
@@ -341,21 +621,13 @@ func (f *SearchRefFilter) GetSearchResultsMarkdown() string {
 	for _, r := range f.searchResults {
 		results = append(results, r)
 	}
-	for i := 0; i < len(results)-1; i++ {
-		for j := i + 1; j < len(results); j++ {
-			if results[i].Index > results[j].Index {
-				results[i], results[j] = results[j], results[i]
-			}
-		}
-	}
+	sortSearchResults(results)
+	return f.renderer.Sources(results)
+}
 
-	var sb strings.Builder
-	for _, r := range results {
-		escapedTitle := escapeMarkdownTitle(r.Title)
-		sb.WriteString(fmt.Sprintf("[\\[%d\\] %s](%s)\n", r.Index, escapedTitle, r.URL))
-	}
-	sb.WriteString("\n")
-	return sb.String()
+// GetCitationFooter 回复结束时追加的内容，如脚注列表
+func (f *SearchRefFilter) GetCitationFooter() string {
+	return f.renderer.Footer(f.cited)
 }
 
 func IsSearchResultContent(editContent string) bool {
@@ -398,10 +670,12 @@ func ParseSearchResults(editContent string) []SearchResult {
 
 	jsonStr := editContent[startIdx:endIdx]
 	var rawResults []struct {
-		Title string `json:"title"`
-		URL   string `json:"url"`
-		Index int    `json:"index"`
-		RefID string `json:"ref_id"`
+		Title   string `json:"title"`
+		URL     string `json:"url"`
+		Index   int    `json:"index"`
+		RefID   string `json:"ref_id"`
+		Snippet string `json:"snippet"`
+		Text    string `json:"text"`
 	}
 
 	if err := json.Unmarshal([]byte(jsonStr), &rawResults); err != nil {
@@ -410,11 +684,16 @@ func ParseSearchResults(editContent string) []SearchResult {
 
 	var results []SearchResult
 	for _, r := range rawResults {
+		snippet := r.Snippet
+		if snippet == "" {
+			snippet = r.Text
+		}
 		results = append(results, SearchResult{
-			Title: r.Title,
-			URL:   r.URL,
-			Index: r.Index,
-			RefID: r.RefID,
+			Title:   r.Title,
+			URL:     r.URL,
+			Index:   r.Index,
+			RefID:   r.RefID,
+			Snippet: snippet,
 		})
 	}
 
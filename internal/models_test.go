@@ -1,6 +1,9 @@
 package internal
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestParseModelNameTags(t *testing.T) {
 	tests := []struct {
@@ -49,3 +52,75 @@ func TestIsToolCallPayload(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFileParts(t *testing.T) {
+	msg := Message{
+		Role: "user",
+		Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "summarize"},
+			map[string]interface{}{"type": "file", "file": map[string]interface{}{
+				"filename":  "report.pdf",
+				"file_data": "data:application/pdf;base64,JVBERi0xLjQK",
+			}},
+			map[string]interface{}{"type": "file", "file": map[string]interface{}{"file_id": "file-123"}},
+		},
+	}
+
+	files := msg.ParseFileParts()
+	if len(files) != 2 {
+		t.Fatalf("len(files) = %d, want 2", len(files))
+	}
+	if files[0].Filename != "report.pdf" || files[1].FileID != "file-123" {
+		t.Fatalf("unexpected file parts: %+v", files)
+	}
+
+	data, declared, err := decodeFileData(files[0].FileData)
+	if err != nil {
+		t.Fatalf("decodeFileData: %v", err)
+	}
+	if got := sniffDocumentType(data, files[0].Filename, declared); got != "application/pdf" {
+		t.Fatalf("sniffDocumentType = %q, want application/pdf", got)
+	}
+	if got := sniffDocumentType([]byte(`{"a":1}`), "data.json", ""); got != "application/json" {
+		t.Fatalf("sniffDocumentType(data.json) = %q, want application/json", got)
+	}
+}
+
+func TestToUpstreamMessagePreservesPartOrder(t *testing.T) {
+	msg := Message{
+		Role: "user",
+		Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "compare "},
+			map[string]interface{}{"type": "text", "text": "image A"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png", "detail": "high"}},
+			map[string]interface{}{"type": "text", "text": "with image B"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/b.png"}},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/failed.png"}},
+			map[string]interface{}{"type": "text", "text": "which is brighter?"},
+		},
+	}
+	urlToFileID := map[string]string{
+		"https://example.com/a.png": "file-a",
+		"https://example.com/b.png": "file-b",
+	}
+
+	got := msg.ToUpstreamMessage(urlToFileID)
+	want := []interface{}{
+		map[string]interface{}{"type": "text", "text": "compare image A"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "file-a", "detail": "high"}},
+		map[string]interface{}{"type": "text", "text": "with image B"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "file-b"}},
+		map[string]interface{}{"type": "text", "text": "which is brighter?"},
+	}
+	if !reflect.DeepEqual(got["content"], want) {
+		t.Fatalf("content = %#v, want %#v", got["content"], want)
+	}
+
+	textOnly := Message{Role: "user", Content: []interface{}{
+		map[string]interface{}{"type": "text", "text": "a"},
+		map[string]interface{}{"type": "text", "text": "b"},
+	}}
+	if got := textOnly.ToUpstreamMessage(nil); got["content"] != "ab" {
+		t.Fatalf("text-only content = %#v, want %q", got["content"], "ab")
+	}
+}
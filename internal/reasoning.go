@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ThinkingConfig Anthropic 风格的 thinking 参数
+type ThinkingConfig struct {
+	Type         string `json:"type"` // enabled / disabled
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// ValidateReasoningParams 校验 reasoning_effort 和 thinking 参数
+func ValidateReasoningParams(req ChatRequest) error {
+	switch req.ReasoningEffort {
+	case "", "none", "low", "medium", "high":
+	default:
+		return fmt.Errorf("invalid reasoning_effort %q, must be none, low, medium or high", req.ReasoningEffort)
+	}
+	if req.Thinking != nil {
+		switch req.Thinking.Type {
+		case "enabled", "disabled":
+		default:
+			return fmt.Errorf("invalid thinking.type %q, must be enabled or disabled", req.Thinking.Type)
+		}
+		if req.Thinking.BudgetTokens < 0 {
+			return fmt.Errorf("thinking.budget_tokens must not be negative")
+		}
+	}
+	return nil
+}
+
+// requestedThinking 请求显式指定的 thinking 开关：thinking 优先于 reasoning_effort，都未指定时 ok 为 false
+func requestedThinking(req ChatRequest) (enabled bool, source string, ok bool) {
+	if req.Thinking != nil {
+		return req.Thinking.Type == "enabled", "thinking.type=" + req.Thinking.Type, true
+	}
+	if req.ReasoningEffort != "" {
+		return req.ReasoningEffort != "none", "reasoning_effort=" + req.ReasoningEffort, true
+	}
+	return false, "", false
+}
+
+// ApplyReasoningParams 用请求参数覆盖模型名的 -thinking 标签，之后的能力校验和降级都按标签处理
+func ApplyReasoningParams(req *ChatRequest) {
+	enabled, source, ok := requestedThinking(*req)
+	if !ok {
+		return
+	}
+	baseModel, tagged, enableSearch := ParseModelName(req.Model)
+	model := FormatModelName(baseModel, enabled, enableSearch)
+	if tagged != enabled {
+		LogInfo("[Reasoning] %s overrides model %s: thinking=%v", source, req.Model, enabled)
+	} else {
+		LogDebug("[Reasoning] %s on %s: thinking=%v", source, req.Model, enabled)
+	}
+	req.Model = model
+}
+
+// 思考内容的输出方式，请求的 reasoning_format 或 REASONING_FORMAT 配置
+const (
+	ReasoningFormatContent = "reasoning_content" // reasoning_content 字段（默认）
+	ReasoningFormatThink   = "think"             // 以 <think>...</think> 写在 content 开头
+	ReasoningFormatSummary = "reasoning"         // OpenAI 风格的 reasoning 摘要对象
+	ReasoningFormatHidden  = "hidden"            // 丢弃
+)
+
+// think 格式下包裹思考内容的标签
+const (
+	thinkOpenTag  = "<think>\n"
+	thinkCloseTag = "\n</think>\n\n"
+)
+
+// ReasoningSummary OpenAI 风格的 reasoning 对象
+type ReasoningSummary struct {
+	Type    string                 `json:"type"`
+	Summary []ReasoningSummaryText `json:"summary"`
+}
+
+type ReasoningSummaryText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func newReasoningSummary(text string) *ReasoningSummary {
+	return &ReasoningSummary{
+		Type:    "reasoning",
+		Summary: []ReasoningSummaryText{{Type: "summary_text", Text: text}},
+	}
+}
+
+// ValidReasoningFormat 判断思考输出方式是否支持，空字符串表示使用默认值
+func ValidReasoningFormat(format string) bool {
+	switch format {
+	case "", ReasoningFormatContent, ReasoningFormatThink, ReasoningFormatSummary, ReasoningFormatHidden:
+		return true
+	}
+	return false
+}
+
+// resolveReasoningFormat 请求未指定时使用配置的默认值
+func resolveReasoningFormat(format string) string {
+	if format != "" {
+		return format
+	}
+	if Cfg == nil || Cfg.ReasoningFormat == "" {
+		return ReasoningFormatContent
+	}
+	return Cfg.ReasoningFormat
+}
+
+// 超出思考预算后重新请求的方式，REASONING_BUDGET_RETRY 配置
+const (
+	ReasoningRetryPartial = "partial" // 把已有的思考内容作为上下文（默认）
+	ReasoningRetryPlain   = "plain"   // 直接以非思考模式重新请求
+)
+
+// reasoningBudget 思考预算，各项为 0 表示不限
+type reasoningBudget struct {
+	MaxChars  int
+	MaxTokens int // 按 estimateTokens 估算：ASCII 约 4 字符 1 token，非 ASCII 1 字符 1 token
+	Timeout   time.Duration
+}
+
+func (b reasoningBudget) enabled() bool {
+	return b.MaxChars > 0 || b.MaxTokens > 0 || b.Timeout > 0
+}
+
+// reasoningAbort 超出思考预算时中止读取，记录已输出的内容供重新请求时衔接
+type reasoningAbort struct {
+	Reasoning    string // 已输出的思考内容
+	ContentRunes int    // 流式已输出的 content 字符数（think 格式下的标签和思考内容）
+}
+
+// requestReasoningBudget 思考模式下的预算：配置值，thinking.budget_tokens 覆盖 token 上限
+func requestReasoningBudget(req ChatRequest) reasoningBudget {
+	if !IsThinkingModel(req.Model) {
+		return reasoningBudget{}
+	}
+	var budget reasoningBudget
+	if Cfg != nil {
+		budget = reasoningBudget{
+			MaxChars:  Cfg.ReasoningBudgetChars,
+			MaxTokens: Cfg.ReasoningBudgetTokens,
+			Timeout:   Cfg.ReasoningBudgetTimeout,
+		}
+	}
+	if req.Thinking != nil && req.Thinking.BudgetTokens > 0 {
+		budget.MaxTokens = req.Thinking.BudgetTokens
+	}
+	return budget
+}
+
+// reasoningRetryRequest 构建超出预算后的重新请求：关闭思考，partial 模式下把已有思考内容追加到系统提示词
+func reasoningRetryRequest(req ChatRequest, partial string) ChatRequest {
+	baseModel, _, enableSearch := ParseModelName(req.Model)
+	retry := req
+	retry.Model = FormatModelName(baseModel, false, enableSearch)
+
+	mode := ReasoningRetryPartial
+	if Cfg != nil && Cfg.ReasoningBudgetRetry != "" {
+		mode = Cfg.ReasoningBudgetRetry
+	}
+	if mode != ReasoningRetryPartial || strings.TrimSpace(partial) == "" || len(req.Messages) == 0 {
+		return retry
+	}
+
+	note := "Your reasoning so far (cut off because it ran too long):\n\n" + partial + "\n\nDo not continue reasoning. Answer directly."
+	messages := make([]Message, len(req.Messages))
+	copy(messages, req.Messages)
+	for i, msg := range messages {
+		role := strings.ToLower(msg.Role)
+		if role != "system" && role != "developer" {
+			continue
+		}
+		// 只追加到第一条系统消息，不改变消息顺序
+		text, _ := msg.ParseContent()
+		messages[i].Content = strings.TrimSpace(text + "\n\n" + note)
+		retry.Messages = messages
+		return retry
+	}
+	retry.Messages = append([]Message{{Role: "system", Content: note}}, messages...)
+	return retry
+}
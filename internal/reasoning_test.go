@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestApplyReasoningParams(t *testing.T) {
+	tests := []struct {
+		req  ChatRequest
+		want string
+	}{
+		{ChatRequest{Model: "GLM-4.7-search"}, "GLM-4.7-search"},
+		{ChatRequest{Model: "GLM-4.7-thinking", ReasoningEffort: "none"}, "GLM-4.7"},
+		{ChatRequest{Model: "GLM-4.7-search", ReasoningEffort: "low"}, "GLM-4.7-thinking-search"},
+		{ChatRequest{Model: "GLM-4.7", Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 2048}}, "GLM-4.7-thinking"},
+		// thinking 优先于 reasoning_effort
+		{ChatRequest{Model: "GLM-4.7", ReasoningEffort: "high", Thinking: &ThinkingConfig{Type: "disabled"}}, "GLM-4.7"},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		ApplyReasoningParams(&req)
+		if req.Model != tt.want {
+			t.Errorf("ApplyReasoningParams(%+v) model = %q, want %q", tt.req, req.Model, tt.want)
+		}
+	}
+
+	if err := ValidateReasoningParams(ChatRequest{ReasoningEffort: "extreme"}); err == nil {
+		t.Error("ValidateReasoningParams accepted reasoning_effort=extreme")
+	}
+	if err := ValidateReasoningParams(ChatRequest{Thinking: &ThinkingConfig{Type: "on"}}); err == nil {
+		t.Error("ValidateReasoningParams accepted thinking.type=on")
+	}
+}
+
+func TestReasoningFormats(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"thinking","delta_content":"> 先想"}}`,
+		`data: {"data":{"phase":"thinking","delta_content":"一想"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"答案"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+
+	for _, format := range []string{ReasoningFormatContent, ReasoningFormatThink, ReasoningFormatSummary, ReasoningFormatHidden} {
+		opts := responseOptions{reasoningFormat: format}
+
+		rec := httptest.NewRecorder()
+		handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+		var resp ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decode response: %v", format, err)
+		}
+		msg := resp.Choices[0].Message
+		reasoning := msg.ReasoningContent
+		if msg.Reasoning != nil {
+			reasoning = msg.Reasoning.Summary[0].Text
+		}
+
+		rec = httptest.NewRecorder()
+		handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+		content, streamReasoning := "", ""
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			var chunk ChatCompletionChunk
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk) != nil || len(chunk.Choices) == 0 {
+				continue
+			}
+			delta := chunk.Choices[0].Delta
+			content += delta.Content
+			streamReasoning += delta.ReasoningContent
+			if delta.Reasoning != nil {
+				streamReasoning += delta.Reasoning.Summary[0].Text
+			}
+		}
+
+		wantContent, wantReasoning := "答案", "先想一想"
+		switch format {
+		case ReasoningFormatThink:
+			wantContent, wantReasoning = "<think>\n先想一想\n</think>\n\n答案", ""
+		case ReasoningFormatHidden:
+			wantReasoning = ""
+		}
+		if *msg.Content != wantContent || reasoning != wantReasoning {
+			t.Errorf("%s: message = (%q, %q), want (%q, %q)", format, *msg.Content, reasoning, wantContent, wantReasoning)
+		}
+		if content != wantContent || streamReasoning != wantReasoning {
+			t.Errorf("%s: stream = (%q, %q), want (%q, %q)", format, content, streamReasoning, wantContent, wantReasoning)
+		}
+	}
+}
+
+func TestReasoningBudgetAborts(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"thinking","delta_content":"> 第一步"}}`,
+		`data: {"data":{"phase":"thinking","delta_content":"第二步"}}`,
+		`data: {"data":{"phase":"thinking","delta_content":"第三步"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"答案"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	opts := responseOptions{budget: reasoningBudget{MaxChars: 5}}
+
+	rec := httptest.NewRecorder()
+	abort := handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+	if abort == nil || abort.Reasoning != "第一步第二步" || rec.Body.Len() != 0 {
+		t.Fatalf("non-stream abort = %+v, body %q", abort, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	opts.reasoningFormat = ReasoningFormatThink
+	abort = handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+	if abort == nil || abort.Reasoning != "第一步第二步" || strings.Contains(rec.Body.String(), "[DONE]") {
+		t.Fatalf("stream abort = %+v, body %q", abort, rec.Body.String())
+	}
+	// think 格式下中止前闭合标签，重新请求的输出从其后接续
+	if want := utf8.RuneCountInString(thinkOpenTag + "第一步第二步" + thinkCloseTag); abort.ContentRunes != want {
+		t.Fatalf("ContentRunes = %d, want %d", abort.ContentRunes, want)
+	}
+
+	// 预算内的请求正常结束
+	rec = httptest.NewRecorder()
+	opts = responseOptions{budget: reasoningBudget{MaxTokens: 100}}
+	if abort := handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts); abort != nil {
+		t.Fatalf("abort within budget: %+v", abort)
+	}
+}
+
+func TestReasoningBudgetTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintln(pw, `data: {"data":{"phase":"thinking","delta_content":"> 想了很久"}}`)
+		// 之后上游不再输出，等待超时关闭
+	}()
+
+	rec := httptest.NewRecorder()
+	opts := responseOptions{budget: reasoningBudget{Timeout: 50 * time.Millisecond}}
+	abort := handleNonStreamResponse(rec, pr, "id", "GLM-4.7-thinking", opts)
+	if abort == nil || abort.Reasoning != "想了很久" {
+		t.Fatalf("abort = %+v", abort)
+	}
+	pw.Close()
+}
+
+func TestReasoningBudgetStopsAfterThinking(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintln(pw, `data: {"data":{"phase":"thinking","delta_content":"> 先搜索"}}`)
+		// 工具调用阶段耗时超过预算，不应中止
+		fmt.Fprintln(pw, `data: {"data":{"phase":"tool_call","edit_content":"searching"}}`)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(pw, `data: {"data":{"phase":"answer","delta_content":"答案"}}`)
+		fmt.Fprintln(pw, `data: {"data":{"phase":"done","done":true}}`)
+		pw.Close()
+	}()
+
+	rec := httptest.NewRecorder()
+	opts := responseOptions{budget: reasoningBudget{Timeout: 50 * time.Millisecond}}
+	if abort := handleNonStreamResponse(rec, pr, "id", "GLM-4.7-thinking", opts); abort != nil {
+		t.Fatalf("abort = %+v", abort)
+	}
+}
+
+func TestReasoningBudgetAcrossThinkingRounds(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"thinking","delta_content":"> 先搜索"}}`,
+		`data: {"data":{"phase":"tool_call","edit_content":"searching"}}`,
+		`data: {"data":{"phase":"thinking","delta_content":"> 再想一想"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"答案"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	opts := responseOptions{budget: reasoningBudget{MaxChars: 5}}
+
+	rec := httptest.NewRecorder()
+	abort := handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+	if abort == nil || !strings.Contains(abort.Reasoning, "再想一想") {
+		t.Fatalf("non-stream abort = %+v, body %q", abort, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	abort = handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts)
+	if abort == nil || !strings.Contains(abort.Reasoning, "再想一想") {
+		t.Fatalf("stream abort = %+v, body %q", abort, rec.Body.String())
+	}
+
+	// 中文按 1 字符 1 token 计算
+	opts = responseOptions{budget: reasoningBudget{MaxTokens: 5}}
+	rec = httptest.NewRecorder()
+	if abort := handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.7-thinking", opts); abort == nil {
+		t.Fatalf("token budget not enforced for CJK reasoning")
+	}
+}
+
+func TestReasoningBudgetTimeoutResumes(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintln(pw, `data: {"data":{"phase":"thinking","delta_content":"> 先搜索"}}`)
+		fmt.Fprintln(pw, `data: {"data":{"phase":"tool_call","edit_content":"searching"}}`)
+		// 内容为空的思考事件也恢复计时，之后上游不再输出，等待超时关闭
+		fmt.Fprintln(pw, `data: {"data":{"phase":"thinking","delta_content":""}}`)
+	}()
+
+	rec := httptest.NewRecorder()
+	opts := responseOptions{budget: reasoningBudget{Timeout: 50 * time.Millisecond}}
+	abort := handleNonStreamResponse(rec, pr, "id", "GLM-4.7-thinking", opts)
+	if abort == nil || abort.Reasoning != "先搜索" {
+		t.Fatalf("abort = %+v", abort)
+	}
+	pw.Close()
+}
+
+func TestReasoningRetryRequest(t *testing.T) {
+	originCfg := Cfg
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+	req := ChatRequest{
+		Model:    "GLM-4.7-thinking-search",
+		Messages: []Message{{Role: "system", Content: "be brief"}, {Role: "user", Content: "why?"}},
+	}
+
+	Cfg = &Config{ReasoningBudgetRetry: ReasoningRetryPartial}
+	retry := reasoningRetryRequest(req, "partial thoughts")
+	if retry.Model != "GLM-4.7-search" || len(retry.Messages) != 2 || retry.Messages[0].Role != "system" ||
+		!strings.HasPrefix(retry.Messages[0].Content.(string), "be brief\n\n") ||
+		!strings.Contains(retry.Messages[0].Content.(string), "partial thoughts") || retry.Messages[1].Content != "why?" {
+		t.Fatalf("partial retry = %+v", retry)
+	}
+	if req.Messages[0].Content != "be brief" {
+		t.Fatalf("original system message modified: %+v", req.Messages[0])
+	}
+	noSystem := ChatRequest{Model: req.Model, Messages: req.Messages[1:]}
+	if retry := reasoningRetryRequest(noSystem, "partial thoughts"); len(retry.Messages) != 2 || retry.Messages[0].Role != "system" ||
+		retry.Messages[1].Content != "why?" {
+		t.Fatalf("partial retry without system message = %+v", retry)
+	}
+
+	Cfg = &Config{ReasoningBudgetRetry: ReasoningRetryPlain}
+	if retry := reasoningRetryRequest(req, "partial thoughts"); retry.Model != "GLM-4.7-search" || len(retry.Messages) != 2 {
+		t.Fatalf("plain retry = %+v", retry)
+	}
+
+	req.Thinking = &ThinkingConfig{Type: "enabled", BudgetTokens: 256}
+	if budget := requestReasoningBudget(req); budget.MaxTokens != 256 {
+		t.Fatalf("budget = %+v", budget)
+	}
+	req.Model = "GLM-4.7"
+	if budget := requestReasoningBudget(req); budget.enabled() {
+		t.Fatalf("budget for non-thinking model = %+v", budget)
+	}
+}
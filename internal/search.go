@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 联网搜索方式，请求的 search 参数
+const (
+	SearchModeAuto  = "auto"  // 由模型决定是否搜索，对应 auto_web_search
+	SearchModeForce = "force" // 强制搜索，对应 web_search
+	SearchModeOff   = "off"   // 不搜索
+)
+
+// WebSearchOptions OpenAI 的 web_search_options，出现即开启搜索；上游没有对应的参数，字段只做兼容
+type WebSearchOptions struct {
+	SearchContextSize string      `json:"search_context_size,omitempty"`
+	UserLocation      interface{} `json:"user_location,omitempty"`
+}
+
+// ValidSearchMode 判断 search 参数是否合法，空字符串表示未指定
+func ValidSearchMode(mode string) bool {
+	switch mode {
+	case "", SearchModeAuto, SearchModeForce, SearchModeOff:
+		return true
+	}
+	return false
+}
+
+// resolveSearchMode 确定搜索方式：search 参数 > web_search_options > 模型名的 -search 标签
+func resolveSearchMode(req ChatRequest, tagged bool) string {
+	mode := SearchModeOff
+	switch {
+	case req.Search != "":
+		mode = req.Search
+	case req.WebSearchOptions != nil:
+		mode = SearchModeAuto
+	case tagged:
+		mode = SearchModeAuto
+	}
+	if tagged && mode != SearchModeAuto {
+		LogDebug("[Search] request overrides -search tag of %s: %s", req.Model, mode)
+	}
+	return mode
+}
+
+// searchFeatures 把搜索方式转换为上游 features 中的 web_search / auto_web_search
+func searchFeatures(mode string) (webSearch bool, autoWebSearch bool) {
+	switch mode {
+	case SearchModeForce:
+		return true, false
+	case SearchModeAuto:
+		return false, true
+	}
+	return false, false
+}
+
+// SearchRequest /v1/search 请求
+type SearchRequest struct {
+	Query         string `json:"query"`
+	Model         string `json:"model,omitempty"`
+	IncludeAnswer bool   `json:"include_answer,omitempty"` // 同时返回模型的回答，引用为 [n]
+}
+
+// SearchResponse /v1/search 响应
+type SearchResponse struct {
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Query   string              `json:"query"`
+	Results []SearchResult      `json:"results"`
+	Images  []ImageSearchResult `json:"images,omitempty"`
+	Answer  string              `json:"answer,omitempty"`
+}
+
+// collectSearchResults 读取上游流，收集搜索结果和图片搜索结果
+// 不需要回答时，搜索结果到达后一进入回答阶段就停止读取
+func collectSearchResults(body io.Reader, includeAnswer bool) ([]SearchResult, []ImageSearchResult, string) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 1024*1024), 8*1024*1024)
+
+	results := []SearchResult{}
+	seen := make(map[string]bool)
+	var images []ImageSearchResult
+	refFilter := NewSearchRefFilter()
+	refFilter.SetCitationFormat(CitationFormatPlain)
+	var answer strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			break
+		}
+
+		var upstream UpstreamData
+		if err := json.Unmarshal([]byte(payload), &upstream); err != nil {
+			continue
+		}
+		if upstream.Data.Phase == "done" {
+			break
+		}
+
+		editContent := upstream.GetEditContent()
+		if editContent != "" && IsSearchResultContent(editContent) {
+			if parsed := ParseSearchResults(editContent); len(parsed) > 0 {
+				// 多轮搜索可能返回相同的来源，按 ref_id 和 URL 去重
+				for _, result := range parsed {
+					if seen["ref:"+result.RefID] || seen["url:"+result.URL] {
+						continue
+					}
+					if result.RefID != "" {
+						seen["ref:"+result.RefID] = true
+					}
+					if result.URL != "" {
+						seen["url:"+result.URL] = true
+					}
+					results = append(results, result)
+				}
+				refFilter.AddSearchResults(parsed)
+			}
+			continue
+		}
+		if editContent != "" && strings.Contains(editContent, `"search_image"`) {
+			images = append(images, ParseImageSearchResults(editContent)...)
+			continue
+		}
+
+		if upstream.Data.Phase == "answer" && upstream.Data.DeltaContent != "" {
+			if !includeAnswer {
+				if len(results) > 0 || len(images) > 0 {
+					break
+				}
+				continue
+			}
+			answer.WriteString(refFilter.Process(upstream.Data.DeltaContent))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		LogError("[Search] scanner error: %v", err)
+	}
+	answer.WriteString(refFilter.Flush())
+
+	sortSearchResults(results)
+	return results, images, strings.TrimSpace(answer.String())
+}
+
+// HandleSearch 处理 POST /v1/search，强制联网搜索并以 JSON 返回上游找到的来源
+func HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := requestToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if req.Model == "" {
+		// 对话的默认模型 GLM-4.6 不支持联网搜索，这里默认使用支持搜索的 GLM-4.7
+		req.Model = "GLM-4.7"
+	}
+
+	chatReq := ChatRequest{
+		Model:    req.Model,
+		Messages: []Message{{Role: "user", Content: req.Query}},
+		Search:   SearchModeForce,
+	}
+	ApplyModelAliases(&chatReq)
+	resolvedModel, err := ResolveRequestModel(chatReq.Model, chatReq.Messages, nil)
+	if err == nil {
+		// 不支持搜索的模型会丢掉 search: force，只能拿到空结果
+		resolvedModel, err = ResolveSearchModel(resolvedModel)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chatReq.Model = resolvedModel
+
+	resp, _, servedModel, err := requestWithFallback(token, chatReq)
+	if err != nil {
+		LogError("Search request failed: %v", err)
+		var statusErr *UpstreamStatusError
+		if errors.As(err, &statusErr) {
+			http.Error(w, "Upstream error", statusErr.StatusCode)
+			return
+		}
+		http.Error(w, "Upstream error", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	results, images, answer := collectSearchResults(resp.Body, req.IncludeAnswer)
+	if len(results) == 0 && len(images) == 0 {
+		LogWarn("[Search] upstream returned no search results for %q", req.Query)
+	}
+
+	writeJSON(w, SearchResponse{
+		Object:  "search.result",
+		Created: time.Now().Unix(),
+		Model:   servedModel,
+		Query:   req.Query,
+		Results: results,
+		Images:  images,
+		Answer:  answer,
+	})
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleSearchRejectsModelWithoutSearch(t *testing.T) {
+	originCfg := Cfg
+	Cfg = &Config{}
+	t.Cleanup(func() {
+		Cfg = originCfg
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/search", strings.NewReader(`{"model":"GLM-4.6","query":"news"}`))
+	req.Header.Set("Authorization", "Bearer "+fakeToken("alice"))
+	rec := httptest.NewRecorder()
+	HandleSearch(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "does not support web search") {
+		t.Fatalf("HandleSearch(GLM-4.6) = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResolveSearchMode(t *testing.T) {
+	tests := []struct {
+		req       ChatRequest
+		tagged    bool
+		webSearch bool
+		auto      bool
+	}{
+		{ChatRequest{}, false, false, false},
+		{ChatRequest{}, true, false, true},
+		{ChatRequest{WebSearchOptions: &WebSearchOptions{}}, false, false, true},
+		{ChatRequest{Search: SearchModeForce}, false, true, false},
+		{ChatRequest{Search: SearchModeOff}, true, false, false},
+		{ChatRequest{Search: SearchModeOff, WebSearchOptions: &WebSearchOptions{}}, false, false, false},
+	}
+	for i, tt := range tests {
+		webSearch, auto := searchFeatures(resolveSearchMode(tt.req, tt.tagged))
+		if webSearch != tt.webSearch || auto != tt.auto {
+			t.Errorf("#%d: features = (%v, %v), want (%v, %v)", i, webSearch, auto, tt.webSearch, tt.auto)
+		}
+	}
+	if ValidSearchMode("always") {
+		t.Error("ValidSearchMode accepted an unknown mode")
+	}
+}
+
+func TestCollectSearchResults(t *testing.T) {
+	searchResult := `{"search_result":[{"title":"B","url":"https://b.example","index":2,"ref_id":"turn0search2"},{"title":"A","url":"https://a.example","index":1,"ref_id":"turn0search1","snippet":"about A"}]}`
+	edit, _ := json.Marshal(searchResult)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"A 是第一个【turn0search1】"}}`,
+		`data: {"data":{"phase":"answer","delta_content":"。"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	want := []SearchResult{
+		{Title: "A", URL: "https://a.example", Index: 1, RefID: "turn0search1", Snippet: "about A"},
+		{Title: "B", URL: "https://b.example", Index: 2, RefID: "turn0search2"},
+	}
+
+	results, images, answer := collectSearchResults(strings.NewReader(upstream), false)
+	if !reflect.DeepEqual(results, want) || len(images) != 0 || answer != "" {
+		t.Fatalf("collectSearchResults = (%+v, %+v, %q)", results, images, answer)
+	}
+	results, _, answer = collectSearchResults(strings.NewReader(upstream), true)
+	if !reflect.DeepEqual(results, want) || answer != "A 是第一个[1]。" {
+		t.Fatalf("collectSearchResults with answer = (%+v, %q)", results, answer)
+	}
+
+	// 第二轮搜索重复返回 A（相同 ref_id）和 B（相同 URL）
+	repeated := `{"search_result":[{"title":"A","url":"https://a.example","index":1,"ref_id":"turn0search1"},{"title":"B","url":"https://b.example","index":3,"ref_id":"turn1search3"}]}`
+	repeatedEdit, _ := json.Marshal(repeated)
+	upstream = strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(repeatedEdit) + `}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	results, _, _ = collectSearchResults(strings.NewReader(upstream), false)
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("collectSearchResults with repeated sources = %+v", results)
+	}
+}
+
+func TestImageSearchResultsStructured(t *testing.T) {
+	block := `<glm_block view="">{"type":"mcp","data":{"metadata":{"name":"search_image","result":[{"type":"text","text":"Title: 橘猫; Link: https://img.example/cat.jpg; Thumbnail: https://img.example/cat_s.jpg"}]}}}</glm_block>`
+	edit, _ := json.Marshal("看看这些图片\n" + block)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"这是一只橘猫"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+	want := []ImageSearchResult{{Title: "橘猫", Link: "https://img.example/cat.jpg", Thumbnail: "https://img.example/cat_s.jpg"}}
+
+	for _, markdown := range []bool{false, true} {
+		opts := responseOptions{citationFormat: CitationFormatMarkdown, imageSearchMarkdown: markdown}
+		wantContent := "看看这些图片这是一只橘猫"
+		if markdown {
+			wantContent = "看看这些图片\n![橘猫](https://img.example/cat.jpg)\n这是一只橘猫"
+		}
+
+		rec := httptest.NewRecorder()
+		handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.6-V", opts)
+		var resp ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		msg := resp.Choices[0].Message
+		if !reflect.DeepEqual(msg.ImageSearchResults, want) || *msg.Content != wantContent {
+			t.Errorf("markdown=%v: message = (%+v, %q)", markdown, msg.ImageSearchResults, *msg.Content)
+		}
+
+		rec = httptest.NewRecorder()
+		handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.6-V", opts)
+		var got []ImageSearchResult
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			var chunk ChatCompletionChunk
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk) == nil && len(chunk.Choices) > 0 {
+				got = append(got, chunk.Choices[0].Delta.ImageSearchResults...)
+			}
+		}
+		if content, _ := streamContent(t, upstream, opts); !reflect.DeepEqual(got, want) || content != wantContent {
+			t.Errorf("markdown=%v: stream = (%+v, %q)", markdown, got, content)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	glmBlockPattern     = regexp.MustCompile(`(?s)<glm_block([^>]*)>(.*?)</glm_block>`)
+	glmBlockAttrPattern = regexp.MustCompile(`tool_call_name="([^"]*)"`)
+)
+
+// ToolTraceEntry z.ai 自身执行的一次工具 / MCP 调用
+type ToolTraceEntry struct {
+	ID               string          `json:"id,omitempty"`
+	Name             string          `json:"name"`
+	Server           string          `json:"server,omitempty"`
+	Arguments        string          `json:"arguments,omitempty"`
+	Result           json.RawMessage `json:"result,omitempty"`
+	Status           string          `json:"status,omitempty"`
+	IsError          bool            `json:"is_error,omitempty"`
+	UpstreamDuration json.RawMessage `json:"upstream_duration,omitempty"` // 上游给出的耗时，原样返回
+	StartedAt        int64           `json:"started_at"`                  // 代理首次看到调用的时间，毫秒
+	ElapsedMs        int64           `json:"elapsed_ms,omitempty"`        // 从首次看到到拿到结果
+}
+
+// glmBlockPayload glm_block 中的 JSON
+type glmBlockPayload struct {
+	Type string `json:"type"`
+	Data struct {
+		Metadata struct {
+			ID        string          `json:"id"`
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+			Result    json.RawMessage `json:"result"`
+			Status    string          `json:"status"`
+			IsError   bool            `json:"is_error"`
+			Duration  json.RawMessage `json:"duration"`
+			MCPServer struct {
+				Name string `json:"name"`
+			} `json:"mcp_server"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+// ToolTrace 从 glm_block 收集上游工具调用记录，同一调用的多次更新合并为一条
+type ToolTrace struct {
+	entries []ToolTraceEntry
+	index   map[string]int
+	done    map[int]bool // 已输出的记录
+	now     func() time.Time
+}
+
+func NewToolTrace() *ToolTrace {
+	return &ToolTrace{
+		index: make(map[string]int),
+		done:  make(map[int]bool),
+		now:   time.Now,
+	}
+}
+
+// Observe 解析 edit_content 中的 glm_block，返回本次新完成的调用
+func (t *ToolTrace) Observe(editContent string) []ToolTraceEntry {
+	if !strings.Contains(editContent, "<glm_block") {
+		return nil
+	}
+
+	var completed []ToolTraceEntry
+	for i, m := range glmBlockPattern.FindAllStringSubmatch(editContent, -1) {
+		var payload glmBlockPayload
+		if err := json.Unmarshal([]byte(m[2]), &payload); err != nil {
+			continue
+		}
+		meta := payload.Data.Metadata
+		name := meta.Name
+		if name == "" {
+			if attr := glmBlockAttrPattern.FindStringSubmatch(m[1]); attr != nil {
+				name = attr[1]
+			}
+		}
+		if name == "" {
+			continue
+		}
+
+		arguments := traceArguments(meta.Arguments)
+		key := meta.ID
+		if key == "" {
+			// 没有 ID 时按工具名和块在 edit_content 中的位置识别，参数可能在后续更新中才补全
+			key = name + "#" + strconv.Itoa(i)
+		}
+		idx, ok := t.index[key]
+		if !ok {
+			idx = len(t.entries)
+			t.index[key] = idx
+			t.entries = append(t.entries, ToolTraceEntry{ID: meta.ID, Name: name, StartedAt: t.now().UnixMilli()})
+		}
+
+		entry := &t.entries[idx]
+		if arguments != "" {
+			entry.Arguments = arguments
+		}
+		if meta.MCPServer.Name != "" {
+			entry.Server = meta.MCPServer.Name
+		}
+		if meta.Status != "" {
+			entry.Status = meta.Status
+		}
+		entry.IsError = entry.IsError || meta.IsError
+		if !emptyJSON(meta.Duration) {
+			entry.UpstreamDuration = meta.Duration
+		}
+		if !emptyJSON(meta.Result) {
+			entry.Result = meta.Result
+		}
+
+		if !t.done[idx] && traceFinished(*entry) {
+			t.done[idx] = true
+			entry.ElapsedMs = t.now().UnixMilli() - entry.StartedAt
+			LogDebug("[ToolTrace] %s finished in %dms", entry.Name, entry.ElapsedMs)
+			completed = append(completed, *entry)
+		}
+	}
+	return completed
+}
+
+// Pending 返回尚未输出（上游未给出结果）的调用，并标记为已输出
+func (t *ToolTrace) Pending() []ToolTraceEntry {
+	var pending []ToolTraceEntry
+	for i, entry := range t.entries {
+		if !t.done[i] {
+			t.done[i] = true
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+// Entries 返回全部调用记录
+func (t *ToolTrace) Entries() []ToolTraceEntry {
+	return t.entries
+}
+
+// traceFinished 拿到结果或状态表明调用已结束
+func traceFinished(entry ToolTraceEntry) bool {
+	if entry.Result != nil || entry.IsError {
+		return true
+	}
+	switch entry.Status {
+	case "completed", "success", "error", "failed":
+		return true
+	}
+	return false
+}
+
+// traceArguments 参数可能是 JSON 字符串或对象，统一为字符串
+func traceArguments(raw json.RawMessage) string {
+	if emptyJSON(raw) {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func emptyJSON(raw json.RawMessage) bool {
+	switch strings.TrimSpace(string(raw)) {
+	case "", "null", `""`, "[]", "{}":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolTraceMergesUpdates(t *testing.T) {
+	running := `正在搜索<glm_block view="" tool_call_name="search">{"type":"mcp","data":{"metadata":{"id":"call_1","name":"search","arguments":"{\"queries\":[\"北京天气\"]}","status":"running","mcp_server":{"name":"mcp-server"}}}}</glm_block>`
+	finished := `<glm_block view="" tool_call_name="search">{"type":"mcp","data":{"metadata":{"id":"call_1","name":"search","arguments":"{\"queries\":[\"北京天气\"]}","result":[{"title":"天气"}],"status":"completed","duration":"1.2s"}}}</glm_block>`
+
+	now := time.UnixMilli(1000)
+	trace := NewToolTrace()
+	trace.now = func() time.Time { return now }
+
+	if done := trace.Observe(running); len(done) != 0 {
+		t.Fatalf("running call reported as finished: %+v", done)
+	}
+	now = now.Add(1500 * time.Millisecond)
+	done := trace.Observe(finished)
+	if len(done) != 1 {
+		t.Fatalf("Observe(finished) = %+v, want one entry", done)
+	}
+	got := done[0]
+	if got.ID != "call_1" || got.Name != "search" || got.Server != "mcp-server" || got.Arguments != `{"queries":["北京天气"]}` ||
+		string(got.Result) != `[{"title":"天气"}]` || got.Status != "completed" || string(got.UpstreamDuration) != `"1.2s"` ||
+		got.StartedAt != 1000 || got.ElapsedMs != 1500 {
+		t.Fatalf("entry = %+v", got)
+	}
+	if again := trace.Observe(finished); len(again) != 0 || len(trace.Pending()) != 0 || len(trace.Entries()) != 1 {
+		t.Fatalf("finished call reported twice")
+	}
+}
+
+func TestToolTraceMergesUpdatesWithoutID(t *testing.T) {
+	running := `<glm_block view="" tool_call_name="search">{"type":"mcp","data":{"metadata":{"name":"search","status":"running"}}}</glm_block>`
+	withArgs := `<glm_block view="" tool_call_name="search">{"type":"mcp","data":{"metadata":{"name":"search","arguments":"{\"queries\":[\"北京天气\"]}","status":"running"}}}</glm_block>`
+	finished := `<glm_block view="" tool_call_name="search">{"type":"mcp","data":{"metadata":{"name":"search","arguments":"{\"queries\":[\"北京天气\"]}","result":[{"title":"天气"}],"status":"completed"}}}</glm_block>`
+
+	trace := NewToolTrace()
+	trace.Observe(running)
+	trace.Observe(withArgs)
+	done := trace.Observe(finished)
+	if len(done) != 1 || done[0].Arguments != `{"queries":["北京天气"]}` || done[0].Status != "completed" {
+		t.Fatalf("Observe(finished) = %+v", done)
+	}
+	if entries := trace.Entries(); len(entries) != 1 {
+		t.Fatalf("entries = %+v, want one merged entry", entries)
+	}
+	if pending := trace.Pending(); len(pending) != 0 {
+		t.Fatalf("pending = %+v, want none", pending)
+	}
+
+	// 同一次更新中的两个同名调用各自一条
+	trace = NewToolTrace()
+	if entries := trace.Observe(finished + finished); len(entries) != 2 {
+		t.Fatalf("two blocks = %+v, want two entries", entries)
+	}
+}
+
+func TestToolTraceInResponse(t *testing.T) {
+	block := `<glm_block view="" tool_call_name="vlm-image-recognition">{"type":"mcp","data":{"metadata":{"name":"vlm-image-recognition","arguments":{"image":"a.png"}}}}</glm_block>`
+	edit, _ := json.Marshal(block)
+	upstream := strings.Join([]string{
+		`data: {"data":{"phase":"tool_call","edit_content":` + string(edit) + `}}`,
+		`data: {"data":{"phase":"answer","delta_content":"一只猫"}}`,
+		`data: {"data":{"phase":"done","done":true}}`,
+	}, "\n")
+
+	for _, enabled := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		handleNonStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.6-V", responseOptions{toolTrace: enabled})
+		var resp ChatCompletionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		trace := resp.Choices[0].Message.ToolTrace
+		if !enabled {
+			if trace != nil {
+				t.Fatalf("tool_trace returned without opt-in: %+v", trace)
+			}
+			continue
+		}
+		if len(trace) != 1 || trace[0].Name != "vlm-image-recognition" || trace[0].Arguments != `{"image":"a.png"}` {
+			t.Fatalf("tool_trace = %+v", trace)
+		}
+
+		// 流式输出中没有结果的调用在结束前输出
+		rec = httptest.NewRecorder()
+		handleStreamResponse(rec, io.NopCloser(strings.NewReader(upstream)), "id", "GLM-4.6-V", responseOptions{toolTrace: true})
+		if !strings.Contains(rec.Body.String(), `"tool_trace":[{"name":"vlm-image-recognition"`) {
+			t.Fatalf("stream has no tool_trace: %s", rec.Body.String())
+		}
+	}
+}
@@ -7,16 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
 var ErrImageUploadUnauthorized = errors.New("image upload unauthorized")
 
+// ErrUploadUnavailable 请求 z.ai 上传接口时的网络错误、超时或响应无法解析
+var ErrUploadUnavailable = errors.New("failed to upload file")
+
 type UploadHTTPError struct {
 	StatusCode int
 	Body       string
@@ -55,113 +57,120 @@ type UpstreamFile struct {
 	SourceURL string `json:"-"`
 }
 
+// newUpstreamFile 根据上传响应构建上游文件格式，media 为 image / file 等
+func newUpstreamFile(uploadResp *FileUploadResponse, media string) *UpstreamFile {
+	return &UpstreamFile{
+		Type:   media,
+		File:   *uploadResp,
+		ID:     uploadResp.ID,
+		URL:    fmt.Sprintf("/api/v1/files/%s/content", uploadResp.ID),
+		Name:   uploadResp.Filename,
+		Status: "uploaded",
+		Size:   uploadResp.Meta.Size,
+		Error:  "",
+		ItemID: uuid.New().String(),
+		Media:  media,
+	}
+}
+
 // UploadImageFromURL 从 URL 或 base64 上传图片到 z.ai
+// 同一用户重复的图片（相同 URL 或相同内容）直接复用缓存中的文件记录
 func UploadImageFromURL(token string, imageURL string) (*UpstreamFile, error) {
-	var imageData []byte
-	var filename string
-	var contentType string
+	cache := GetUploadCache()
+	urlKey := uploadCacheKey(tokenUserID(token), "url", []byte(imageURL))
+	if file, ok := cache.Get(urlKey); ok {
+		return file, nil
+	}
 
+	var file *UpstreamFile
+	var err error
 	if strings.HasPrefix(imageURL, "data:") {
-		// Base64 编码的图片
-		// 格式: data:image/jpeg;base64,/9j/4AAQ...
-		parts := strings.SplitN(imageURL, ",", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid base64 image format")
-		}
-
-		// 解析 MIME 类型
-		header := parts[0] // data:image/jpeg;base64
-		if idx := strings.Index(header, ":"); idx != -1 {
-			mimeAndEncoding := header[idx+1:]
-			if semiIdx := strings.Index(mimeAndEncoding, ";"); semiIdx != -1 {
-				contentType = mimeAndEncoding[:semiIdx]
-			}
-		}
-		if contentType == "" {
-			contentType = "image/png"
-		}
-
-		// 解码 base64
-		var err error
-		imageData, err = base64.StdEncoding.DecodeString(parts[1])
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode base64: %v", err)
-		}
-
-		// 生成文件名
-		ext := ".png"
-		if strings.Contains(contentType, "jpeg") || strings.Contains(contentType, "jpg") {
-			ext = ".jpg"
-		} else if strings.Contains(contentType, "gif") {
-			ext = ".gif"
-		} else if strings.Contains(contentType, "webp") {
-			ext = ".webp"
-		}
-		filename = uuid.New().String()[:12] + ext
+		file, err = uploadDataURLImage(token, imageURL)
 	} else {
-		// 从 URL 下载图片
-		client := GetStickyProxyClient(token)
-		resp, err := client.Get(imageURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to download image: %v", err)
-		}
-		defer resp.Body.Close()
+		file, err = uploadRemoteImage(token, imageURL)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to download image: status %d", resp.StatusCode)
-		}
+	cache.Put(urlKey, file)
+	return file, nil
+}
 
-		imageData, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read image data: %v", err)
-		}
+// uploadImageData 预处理（缩放、转码）后上传已读入内存的图片
+func uploadImageData(token string, data []byte, filename string) (*UpstreamFile, error) {
+	cache := GetUploadCache()
+	contentKey := uploadCacheKey(tokenUserID(token), "sha256", data)
+	if file, ok := cache.Get(contentKey); ok {
+		return file, nil
+	}
 
-		contentType = resp.Header.Get("Content-Type")
-		if contentType == "" {
-			contentType = "image/png"
-		}
+	data, contentType, err := preprocessImage(data)
+	if err != nil {
+		return nil, err
+	}
+	filename = imageFilename(filename, contentType)
 
-		// 从 URL 提取文件名
-		filename = filepath.Base(imageURL)
-		if filename == "" || filename == "." || filename == "/" {
-			ext := ".png"
-			if strings.Contains(contentType, "jpeg") || strings.Contains(contentType, "jpg") {
-				ext = ".jpg"
-			}
-			filename = uuid.New().String()[:12] + ext
-		}
+	uploadResp, err := uploadFileData(token, data, filename, contentType)
+	if err != nil {
+		return nil, err
 	}
 
-	// 构建 multipart form 请求
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	file := newUpstreamFile(uploadResp, "image")
+	cache.Put(contentKey, file)
+	return file, nil
+}
+
+// decodeDataURL 解码 base64 data URL，返回数据和声明的 MIME 类型
+// 格式: data:image/jpeg;base64,/9j/4AAQ...
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	return decodeDataURLWithLimit(dataURL, fetchMaxBytes())
+}
 
-	part, err := writer.CreateFormFile("file", filename)
+// decodeDataURLWithLimit 同 decodeDataURL，使用指定的大小上限
+func decodeDataURLWithLimit(dataURL string, maxBytes int64) ([]byte, string, error) {
+	contentType, payload, err := splitDataURL(dataURL, maxBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+		return nil, "", err
 	}
 
-	if _, err := part.Write(imageData); err != nil {
-		return nil, fmt.Errorf("failed to write image data: %v", err)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode base64: %v", err)
 	}
+	return data, contentType, nil
+}
+
+// uploadFileData 以 multipart 形式上传内存中的文件到 z.ai
+func uploadFileData(token string, data []byte, filename, contentType string) (*FileUploadResponse, error) {
+	return uploadFileStream(token, bytes.NewReader(data), int64(len(data)), filename, contentType)
+}
 
-	writer.Close()
+// uploadFileStream 以 multipart 形式流式上传文件到 z.ai，size < 0 时使用分块传输
+func uploadFileStream(token string, src io.Reader, size int64, filename, contentType string) (*FileUploadResponse, error) {
+	body := newMultipartStream(src, size, filename, contentType)
 
 	// 发送上传请求
-	req, err := http.NewRequest("POST", "https://chat.z.ai/api/v1/files/", &buf)
+	req, err := http.NewRequest("POST", "https://chat.z.ai/api/v1/files/", body)
 	if err != nil {
+		body.Finish()
 		return nil, fmt.Errorf("failed to create upload request: %v", err)
 	}
+	req.ContentLength = body.ContentLength
 
 	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Type", body.ContentType)
 	req.Header.Set("Origin", "https://chat.z.ai")
 	req.Header.Set("Referer", "https://chat.z.ai/")
 
 	client := GetStickyProxyClient(token)
 	resp, err := client.Do(req)
+	streamErr := body.Finish()
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload image: %v", err)
+		if streamErr != nil && streamErr != io.ErrClosedPipe {
+			return nil, fmt.Errorf("failed to read file data: %v", streamErr)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUploadUnavailable, err)
 	}
 	defer resp.Body.Close()
 
@@ -179,57 +188,156 @@ func UploadImageFromURL(token string, imageURL string) (*UpstreamFile, error) {
 
 	var uploadResp FileUploadResponse
 	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
-		return nil, fmt.Errorf("failed to parse upload response: %v", err)
+		return nil, fmt.Errorf("%w: failed to parse upload response: %v", ErrUploadUnavailable, err)
 	}
 
-	// 构建上游文件格式
-	return &UpstreamFile{
-		Type:   "image",
-		File:   uploadResp,
-		ID:     uploadResp.ID,
-		URL:    fmt.Sprintf("/api/v1/files/%s/content", uploadResp.ID),
-		Name:   uploadResp.Filename,
-		Status: "uploaded",
-		Size:   uploadResp.Meta.Size,
-		Error:  "",
-		ItemID: uuid.New().String(),
-		Media:  "image",
-	}, nil
+	return &uploadResp, nil
+}
+
+// ImageUploadFailure 单张图片上传失败的信息
+type ImageUploadFailure struct {
+	Index int
+	URL   string
+	Err   error
+}
+
+// ImageUploadError 严格模式下有图片上传失败时返回，列出失败的图片序号和原因
+type ImageUploadError struct {
+	Failures []ImageUploadFailure
+}
+
+func (e *ImageUploadError) Error() string {
+	var parts []string
+	for _, f := range e.Failures {
+		reason := f.Err.Error()
+		var httpErr *UploadHTTPError
+		if errors.As(f.Err, &httpErr) {
+			reason = fmt.Sprintf("z.ai rejected upload with status %d: %s", httpErr.StatusCode, httpErr.Body)
+		}
+		parts = append(parts, fmt.Sprintf("image #%d: %s", f.Index, reason))
+	}
+	return fmt.Sprintf("%d image(s) failed to upload: %s", len(e.Failures), strings.Join(parts, "; "))
+}
+
+// StatusCode 全部失败都出在 z.ai 上传接口时返回 502，否则是请求本身的问题，返回 400
+func (e *ImageUploadError) StatusCode() int {
+	for _, f := range e.Failures {
+		if !isUpstreamUploadFailure(f.Err) {
+			return http.StatusBadRequest
+		}
+	}
+	return http.StatusBadGateway
+}
+
+// isUpstreamUploadFailure z.ai 上传接口返回 5xx、网络错误或超时
+func isUpstreamUploadFailure(err error) bool {
+	var httpErr *UploadHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	return errors.Is(err, ErrUploadUnavailable)
+}
+
+func (e *ImageUploadError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+func uploadConcurrency() int {
+	if Cfg == nil || Cfg.UploadConcurrency <= 0 {
+		return 4
+	}
+	return Cfg.UploadConcurrency
+}
+
+func uploadStrict() bool {
+	return Cfg != nil && Cfg.UploadStrict
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
 }
 
-// UploadImages 批量上传图片
+// uploadImage 上传单张图片，测试中替换
+var uploadImage = UploadImageFromURL
+
+// UploadImages 批量并发上传图片，返回结果保持输入顺序
+// 重复的 URL 只上传一次；严格模式下任一图片失败即返回 ImageUploadError
 func UploadImages(token string, imageURLs []string) ([]*UpstreamFile, error) {
+	results := make([]*UpstreamFile, len(imageURLs))
+	errs := make([]error, len(imageURLs))
+
+	firstIndex := make(map[string]int, len(imageURLs))
+	var uniqueIndexes []int
+	for i, url := range imageURLs {
+		if _, ok := firstIndex[url]; ok {
+			continue
+		}
+		firstIndex[url] = i
+		uniqueIndexes = append(uniqueIndexes, i)
+	}
+
+	sem := make(chan struct{}, uploadConcurrency())
+	var wg sync.WaitGroup
+	for _, idx := range uniqueIndexes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = uploadImage(token, imageURLs[i])
+		}(idx)
+	}
+	wg.Wait()
+
 	var files []*UpstreamFile
-	var firstErr error
+	var failures []ImageUploadFailure
 	failedCount := 0
 	unauthorizedCount := 0
 
-	for _, url := range imageURLs {
-		file, err := UploadImageFromURL(token, url)
-		if err != nil {
-			LogError("Failed to upload image %s: %v", url[:min(50, len(url))], err)
-			failedCount++
-			if firstErr == nil {
-				firstErr = err
-			}
-			var httpErr *UploadHTTPError
-			if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
-				unauthorizedCount++
+	for i, url := range imageURLs {
+		src := firstIndex[url]
+		if err := errs[src]; err != nil {
+			if src == i {
+				failedCount++
+				LogError("Failed to upload image #%d %s: %v", i, url[:min(50, len(url))], err)
+				var httpErr *UploadHTTPError
+				if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+					unauthorizedCount++
+				}
 			}
+			failures = append(failures, ImageUploadFailure{Index: i, URL: url, Err: err})
 			continue
 		}
+		if src != i {
+			// 同一图片在历史中出现多次，上游只需要一份
+			continue
+		}
+		file := results[i]
 		file.SourceURL = url
 		files = append(files, file)
 	}
 
-	if len(imageURLs) > 0 && len(files) == 0 {
-		if unauthorizedCount == failedCount && failedCount > 0 {
-			return nil, fmt.Errorf("%w: token has no permission for file upload", ErrImageUploadUnauthorized)
-		}
-		if firstErr != nil {
-			return nil, fmt.Errorf("all image uploads failed: %w", firstErr)
-		}
-		return nil, fmt.Errorf("all image uploads failed")
+	stats := GetUploadCache().Stats()
+	LogDebug("[UploadCache] hits=%d misses=%d evictions=%d size=%d", stats.Hits, stats.Misses, stats.Evictions, stats.Size)
+
+	if len(failures) == 0 {
+		return files, nil
+	}
+
+	if len(files) == 0 && unauthorizedCount > 0 && unauthorizedCount == failedCount {
+		return nil, fmt.Errorf("%w: token has no permission for file upload", ErrImageUploadUnauthorized)
+	}
+	if uploadStrict() {
+		return nil, &ImageUploadError{Failures: failures}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("all image uploads failed: %w", failures[0].Err)
 	}
 
 	return files, nil
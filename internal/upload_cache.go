@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadCacheStats 上传缓存统计
+type UploadCacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+type uploadCacheEntry struct {
+	key      string
+	file     UpstreamFile
+	expireAt time.Time
+}
+
+// UploadCache 已上传文件的 LRU 缓存，带 TTL 和容量上限
+type UploadCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+	stats    UploadCacheStats
+}
+
+var (
+	uploadCacheInstance *UploadCache
+	uploadCacheOnce     sync.Once
+)
+
+func newUploadCache(capacity int, ttl time.Duration) *UploadCache {
+	return &UploadCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// GetUploadCache 返回全局上传缓存 (单例)
+func GetUploadCache() *UploadCache {
+	uploadCacheOnce.Do(func() {
+		capacity, ttl := 512, 1*time.Hour
+		if Cfg != nil {
+			capacity, ttl = Cfg.UploadCacheSize, Cfg.UploadCacheTTL
+		}
+		uploadCacheInstance = newUploadCache(capacity, ttl)
+	})
+	return uploadCacheInstance
+}
+
+func tokenUserID(token string) string {
+	if payload, err := DecodeJWTPayload(token); err == nil && payload != nil {
+		return payload.ID
+	}
+	return ""
+}
+
+// uploadCacheKey 按用户隔离，kind 区分 URL 和内容摘要
+func uploadCacheKey(userID, kind string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return uploadCacheKeyFromSum(userID, kind, sum[:])
+}
+
+// uploadCacheKeyFromSum 流式上传时摘要边读边算
+func uploadCacheKeyFromSum(userID, kind string, sum []byte) string {
+	return userID + ":" + kind + ":" + hex.EncodeToString(sum)
+}
+
+// Get 命中时返回文件记录的副本（重新生成 ItemID）
+func (c *UploadCache) Get(key string) (*UpstreamFile, bool) {
+	if c.capacity <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := elem.Value.(*uploadCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.removeElement(elem)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	c.stats.Hits++
+	LogDebug("[UploadCache] hit %s (hits=%d, misses=%d, size=%d)", entry.file.ID, c.stats.Hits, c.stats.Misses, c.ll.Len())
+
+	file := entry.file
+	file.ItemID = uuid.New().String()
+	return &file, true
+}
+
+func (c *UploadCache) Put(key string, file *UpstreamFile) {
+	if c.capacity <= 0 || file == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := *file
+	stored.SourceURL = ""
+	expireAt := time.Now().Add(c.ttl)
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*uploadCacheEntry)
+		entry.file = stored
+		entry.expireAt = expireAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&uploadCacheEntry{key: key, file: stored, expireAt: expireAt})
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// RemoveFileID 删除指向某个 z.ai 文件的全部缓存项，上游文件被删除后调用
+func (c *UploadCache) RemoveFileID(fileID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for elem := c.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*uploadCacheEntry).file.ID == fileID {
+			c.removeElement(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+func (c *UploadCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*uploadCacheEntry).key)
+}
+
+// Stats 返回缓存命中统计
+func (c *UploadCache) Stats() UploadCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.ll.Len()
+	return stats
+}
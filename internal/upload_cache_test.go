@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadCacheLRUAndTTL(t *testing.T) {
+	cache := newUploadCache(2, time.Hour)
+	cache.Put("a", &UpstreamFile{ID: "file-a", ItemID: "item-a", SourceURL: "https://example.com/a.png"})
+	cache.Put("b", &UpstreamFile{ID: "file-b"})
+
+	file, ok := cache.Get("a")
+	if !ok || file.ID != "file-a" {
+		t.Fatalf("Get(a) = (%+v, %v), want file-a", file, ok)
+	}
+	if file.ItemID == "item-a" || file.SourceURL != "" {
+		t.Fatalf("cached file should get a fresh ItemID and no SourceURL: %+v", file)
+	}
+
+	// a 刚被访问，容量满时淘汰 b
+	cache.Put("c", &UpstreamFile{ID: "file-c"})
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("Get(b) should miss after eviction")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 1 || stats.Size != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	expired := newUploadCache(2, -time.Second)
+	expired.Put("a", &UpstreamFile{ID: "file-a"})
+	if _, ok := expired.Get("a"); ok {
+		t.Fatalf("Get(a) should miss after TTL")
+	}
+}
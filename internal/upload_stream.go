@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// multipartStream 通过 io.Pipe 边读边写的 multipart 请求体，内存占用与文件大小无关
+type multipartStream struct {
+	*io.PipeReader
+	ContentType   string
+	ContentLength int64 // 源长度未知时为 -1
+	done          chan error
+}
+
+// newMultipartStream 构建只有一个 file 字段的 multipart 请求体，size < 0 表示长度未知
+func newMultipartStream(src io.Reader, size int64, filename, contentType string) *multipartStream {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, escapeQuotes(filename)))
+	partHeader.Set("Content-Type", contentType)
+
+	contentLength := int64(-1)
+	if size >= 0 {
+		contentLength = multipartOverhead(writer.Boundary(), partHeader) + size
+	}
+
+	s := &multipartStream{
+		PipeReader:    pr,
+		ContentType:   writer.FormDataContentType(),
+		ContentLength: contentLength,
+		done:          make(chan error, 1),
+	}
+
+	go func() {
+		part, err := writer.CreatePart(partHeader)
+		if err == nil {
+			_, err = io.Copy(part, src)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+		s.done <- err
+	}()
+
+	return s
+}
+
+// Finish 结束读取并等待写入协程退出，返回写入过程中的错误
+func (s *multipartStream) Finish() error {
+	s.PipeReader.Close()
+	return <-s.done
+}
+
+// multipartOverhead 计算 multipart 边界和头部的字节数，用于设置 Content-Length
+func multipartOverhead(boundary string, partHeader textproto.MIMEHeader) int64 {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	writer.SetBoundary(boundary)
+	writer.CreatePart(partHeader)
+	writer.Close()
+	return int64(buf.Len())
+}
+
+// splitDataURL 拆出 data URL 声明的 MIME 类型和 base64 数据，并按大小上限预检
+func splitDataURL(dataURL string, maxBytes int64) (string, string, error) {
+	parts := strings.SplitN(dataURL, ",", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid base64 data url format")
+	}
+
+	// 解析 MIME 类型
+	contentType := ""
+	header := parts[0] // data:image/jpeg;base64
+	if idx := strings.Index(header, ":"); idx != -1 {
+		mimeAndEncoding := header[idx+1:]
+		if semiIdx := strings.Index(mimeAndEncoding, ";"); semiIdx != -1 {
+			contentType = mimeAndEncoding[:semiIdx]
+		}
+	}
+
+	if int64(base64.StdEncoding.DecodedLen(len(parts[1]))) > maxBytes {
+		return "", "", fmt.Errorf("base64 data exceeds limit %d", maxBytes)
+	}
+	return contentType, parts[1], nil
+}
+
+// openBase64 流式解码，不保留解码后的数据
+func openBase64(payload string) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(payload))
+}
+
+// base64DecodedSize 不解码计算 base64 数据的长度，含换行等无法直接计算时返回 -1
+func base64DecodedSize(payload string) int64 {
+	n := len(payload)
+	if n%4 != 0 || strings.ContainsAny(payload, "\r\n") {
+		return -1
+	}
+	size := int64(n / 4 * 3)
+	if strings.HasSuffix(payload, "==") {
+		size -= 2
+	} else if strings.HasSuffix(payload, "=") {
+		size--
+	}
+	return size
+}
+
+// probeImage 只读取图片头部识别类型和尺寸，返回已读取的数据供后续拼接
+func probeImage(r io.Reader) (string, int, []byte, error) {
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	// http.DetectContentType 最多看 512 字节
+	if header.Len() < 512 {
+		if _, copyErr := io.CopyN(&header, r, int64(512-header.Len())); copyErr != nil && copyErr != io.EOF {
+			return "", 0, header.Bytes(), copyErr
+		}
+	}
+	contentType := sniffImageType(header.Bytes())
+	if err != nil {
+		return contentType, 0, header.Bytes(), err
+	}
+	return contentType, cfg.Width * cfg.Height, header.Bytes(), nil
+}
+
+// streamableImage 类型可直接上传且未超出大小和像素限制的图片无需解码，可流式上传
+func streamableImage(contentType string, pixels int, size int64) bool {
+	_, uploadable := uploadableImageTypes[contentType]
+	return uploadable && pixels <= imageMaxPixels() && size >= 0 && size <= int64(imageMaxBytes())
+}
+
+// uploadDataURLImage 上传 base64 图片：只解码一遍，边解码边上传，摘要在上传过程中计算
+// 需要缩放或转码时回退到 uploadImageData，此时整张图片读入内存，大小受 FETCH_MAX_BYTES 限制
+func uploadDataURLImage(token, dataURL string) (*UpstreamFile, error) {
+	_, payload, err := splitDataURL(dataURL, fetchMaxBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	filename := uuid.New().String()[:12]
+	size := base64DecodedSize(payload)
+	decoder := openBase64(payload)
+	contentType, pixels, header, err := probeImage(decoder)
+	src := io.MultiReader(bytes.NewReader(header), decoder)
+	if err != nil || !streamableImage(contentType, pixels, size) {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64: %v", err)
+		}
+		return uploadImageData(token, data, filename)
+	}
+
+	hash := sha256.New()
+	uploadResp, err := uploadFileStream(token, io.TeeReader(src, hash), size, imageFilename(filename, contentType), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newUpstreamFile(uploadResp, "image")
+	GetUploadCache().Put(uploadCacheKeyFromSum(tokenUserID(token), "sha256", hash.Sum(nil)), file)
+	return file, nil
+}
+
+// uploadRemoteImage 下载并上传图片：长度已知且无需转码时边下载边上传，摘要在上传过程中计算
+// 长度未知或需要缩放、转码时整张读入内存，大小受 FETCH_MAX_BYTES 限制
+func uploadRemoteImage(token, imageURL string) (*UpstreamFile, error) {
+	body, _, size, err := OpenRemote(imageURL, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer body.Close()
+
+	// 从 URL 路径提取文件名，扩展名按真实类型修正
+	filename := ""
+	if u, err := url.Parse(imageURL); err == nil {
+		filename = path.Base(u.Path)
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = uuid.New().String()[:12]
+	}
+
+	contentType, pixels, header, err := probeImage(body)
+	src := io.MultiReader(bytes.NewReader(header), body)
+	if err != nil || !streamableImage(contentType, pixels, size) {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download image: %w", err)
+		}
+		return uploadImageData(token, data, filename)
+	}
+
+	hash := sha256.New()
+	uploadResp, err := uploadFileStream(token, io.TeeReader(src, hash), size, imageFilename(filename, contentType), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newUpstreamFile(uploadResp, "image")
+	GetUploadCache().Put(uploadCacheKeyFromSum(tokenUserID(token), "sha256", hash.Sum(nil)), file)
+	return file, nil
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestMultipartStreamMatchesContentLength(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	payload := base64.StdEncoding.EncodeToString(data)
+
+	stream := newMultipartStream(openBase64(payload), int64(len(data)), `a "quoted".png`, "image/png")
+	body, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read multipart stream: %v", err)
+	}
+	if err := stream.Finish(); err != nil {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if int64(len(body)) != stream.ContentLength {
+		t.Fatalf("body is %d bytes, ContentLength = %d", len(body), stream.ContentLength)
+	}
+
+	_, params, err := mime.ParseMediaType(stream.ContentType)
+	if err != nil {
+		t.Fatalf("parse content type: %v", err)
+	}
+	part, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("read part: %v", err)
+	}
+	got, _ := io.ReadAll(part)
+	if part.FileName() != `a "quoted".png` || part.Header.Get("Content-Type") != "image/png" || !bytes.Equal(got, data) {
+		t.Fatalf("unexpected part: filename=%q type=%q len=%d", part.FileName(), part.Header.Get("Content-Type"), len(got))
+	}
+
+	broken := newMultipartStream(openBase64("!!!!"), 3, "a.png", "image/png")
+	if _, err := io.ReadAll(broken); err == nil {
+		t.Fatalf("invalid base64 should fail the stream")
+	}
+	broken.Finish()
+}
+
+func TestBase64DecodedSize(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 4, 100} {
+		payload := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, n))
+		if got := base64DecodedSize(payload); got != int64(n) {
+			t.Fatalf("base64DecodedSize(%d bytes) = %d", n, got)
+		}
+	}
+	if got := base64DecodedSize("AAAA\nAAAA"); got != -1 {
+		t.Fatalf("base64DecodedSize(with newline) = %d, want -1", got)
+	}
+}
+
+// BenchmarkUploadDataURLImage 走完整的 data URL 上传流程，每次分配的内存应与图片大小无关
+// BenchmarkUploadDataURLImage 使用默认配置：8MB 以内流式上传，16MB 超出 IMAGE_MAX_BYTES，走整张读入内存的回退路径
+func BenchmarkUploadDataURLImage(b *testing.B) {
+	originCfg := Cfg
+	Cfg = &Config{}
+	b.Cleanup(func() {
+		Cfg = originCfg
+	})
+	useFakeUpstream(b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"id":"bench","filename":"a.png"}`))
+	}))
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		b.Fatal(err)
+	}
+	token := fakeToken("bench")
+	for _, size := range []int{1 << 20, 8 << 20, 16 << 20} {
+		// PNG 结尾之后的填充数据不影响识别，只用来撑大上传体积
+		data := append(append([]byte(nil), pngBuf.Bytes()...), bytes.Repeat([]byte{0xAB}, size-pngBuf.Len())...)
+		dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if _, err := uploadDataURLImage(token, dataURL); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImageUploadErrorListsFailures(t *testing.T) {
+	err := &ImageUploadError{Failures: []ImageUploadFailure{
+		{Index: 1, URL: "https://example.com/b.png", Err: &UploadHTTPError{StatusCode: 413, Body: "too large"}},
+		{Index: 3, URL: "https://example.com/d.png", Err: errors.New("failed to download image: status 404")},
+	}}
+
+	msg := err.Error()
+	for _, want := range []string{"2 image(s)", "image #1: z.ai rejected upload with status 413: too large", "image #3: failed to download image"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error message %q missing %q", msg, want)
+		}
+	}
+
+	var httpErr *UploadHTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 413 {
+		t.Fatalf("errors.As should find the UploadHTTPError, got %v", httpErr)
+	}
+}
+
+func TestUploadImagesConcurrent(t *testing.T) {
+	originCfg, originUpload := Cfg, uploadImage
+	Cfg = &Config{UploadConcurrency: 2, UploadStrict: true}
+	t.Cleanup(func() {
+		Cfg, uploadImage = originCfg, originUpload
+	})
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	var running, maxRunning int32
+	uploadImage = func(token, imageURL string) (*UpstreamFile, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		mu.Lock()
+		calls[imageURL]++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return &UpstreamFile{ID: "id-" + imageURL}, nil
+	}
+
+	urls := []string{"e", "d", "c", "d", "b", "a"}
+	files, err := UploadImages("token", urls)
+	if err != nil {
+		t.Fatalf("UploadImages() error = %v", err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.SourceURL)
+	}
+	if strings.Join(got, ",") != "e,d,c,b,a" {
+		t.Fatalf("files = %v, want input order without duplicates", got)
+	}
+	if calls["d"] != 1 || len(calls) != 5 {
+		t.Fatalf("upload calls = %v, want each URL once", calls)
+	}
+	if maxRunning > 2 {
+		t.Fatalf("max concurrent uploads = %d, want <= 2", maxRunning)
+	}
+
+	uploadImage = func(token, imageURL string) (*UpstreamFile, error) {
+		if imageURL == "bad" {
+			return nil, errors.New("failed to download image: status 404")
+		}
+		return nil, &UploadHTTPError{StatusCode: 503, Body: "busy"}
+	}
+	var uploadErr *ImageUploadError
+	if _, err := UploadImages("token", []string{"a", "b"}); !errors.As(err, &uploadErr) || uploadErr.StatusCode() != http.StatusBadGateway {
+		t.Fatalf("upstream 503 error = %v, want 502", err)
+	}
+	if _, err := UploadImages("token", []string{"a", "bad"}); !errors.As(err, &uploadErr) || uploadErr.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("download failure error = %v, want 400", err)
+	}
+}
+
+// upstreamTransport 把发往 z.ai 的请求改写到测试服务器
+type upstreamTransport struct {
+	target *url.URL
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// useFakeUpstream 测试期间所有上游请求发往 handler
+func useFakeUpstream(tb testing.TB, handler http.Handler) {
+	server := httptest.NewServer(handler)
+	target, _ := url.Parse(server.URL)
+
+	proxyClientOnce.Do(func() {})
+	origin := proxyClient
+	proxyClient = &http.Client{Transport: upstreamTransport{target: target}}
+	tb.Cleanup(func() {
+		server.Close()
+		proxyClient = origin
+		if proxyClient == nil {
+			proxyClient = newClient("")
+		}
+	})
+}
+
+// fakeToken 只带 id 的 JWT，签名不会被校验
+func fakeToken(userID string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"id":"` + userID + `"}`))
+	return "e30." + payload + ".sig"
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// VideoUploadError 视频上传失败，Index 为视频在请求中的序号
+type VideoUploadError struct {
+	Index int
+	URL   string
+	Err   error
+}
+
+func (e *VideoUploadError) Error() string {
+	return fmt.Sprintf("video #%d failed to upload: %v", e.Index, e.Err)
+}
+
+func (e *VideoUploadError) Unwrap() error {
+	return e.Err
+}
+
+func videoMaxBytes() int64 {
+	if Cfg == nil || Cfg.VideoMaxBytes <= 0 {
+		return 50 * 1024 * 1024
+	}
+	return int64(Cfg.VideoMaxBytes)
+}
+
+// sniffVideoType 按内容识别视频类型，无法识别时参考声明的类型
+func sniffVideoType(data []byte, declared string) (string, error) {
+	// http.DetectContentType 只识别 mp4 品牌的 ftyp，QuickTime 需要单独判断
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && string(data[8:12]) == "qt  " {
+		return "video/quicktime", nil
+	}
+	contentType := http.DetectContentType(data)
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	if strings.HasPrefix(contentType, "video/") {
+		return contentType, nil
+	}
+	if bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		return "video/x-matroska", nil
+	}
+	if strings.HasPrefix(declared, "video/") && contentType == "application/octet-stream" {
+		return declared, nil
+	}
+	return "", fmt.Errorf("not a video (detected %s)", contentType)
+}
+
+// loadVideo 读取视频数据：解码 base64 data URL 或下载远程视频
+func loadVideo(videoURL string) ([]byte, string, string, error) {
+	if strings.HasPrefix(videoURL, "data:") {
+		data, contentType, err := decodeDataURLWithLimit(videoURL, videoMaxBytes())
+		return data, "", contentType, err
+	}
+
+	data, contentType, err := FetchRemote(videoURL, videoMaxBytes())
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to download video: %w", err)
+	}
+	filename := ""
+	if u, err := url.Parse(videoURL); err == nil {
+		filename = path.Base(u.Path)
+	}
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	return data, filename, strings.TrimSpace(contentType), nil
+}
+
+// UploadVideo 从 URL 或 base64 上传视频到 z.ai，media 为 video
+func UploadVideo(token, videoURL string) (*UpstreamFile, error) {
+	cache := GetUploadCache()
+	userID := tokenUserID(token)
+	urlKey := uploadCacheKey(userID, "url", []byte(videoURL))
+	if file, ok := cache.Get(urlKey); ok {
+		return file, nil
+	}
+
+	data, filename, declared, err := loadVideo(videoURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty video")
+	}
+
+	contentKey := uploadCacheKey(userID, "sha256", data)
+	if file, ok := cache.Get(contentKey); ok {
+		cache.Put(urlKey, file)
+		return file, nil
+	}
+
+	contentType, err := sniffVideoType(data, declared)
+	if err != nil {
+		return nil, err
+	}
+	filename = documentFilename(filename, contentType)
+
+	uploadResp, err := uploadFileData(token, data, filename, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newUpstreamFile(uploadResp, "video")
+	cache.Put(contentKey, file)
+	cache.Put(urlKey, file)
+	return file, nil
+}
+
+// UploadVideos 按顺序上传视频，任一失败即返回 VideoUploadError
+func UploadVideos(token string, videoURLs []string) ([]*UpstreamFile, error) {
+	files := make([]*UpstreamFile, 0, len(videoURLs))
+	seen := make(map[string]bool)
+	for i, videoURL := range videoURLs {
+		if seen[videoURL] {
+			continue
+		}
+		seen[videoURL] = true
+
+		file, err := UploadVideo(token, videoURL)
+		if err != nil {
+			LogError("Failed to upload video #%d: %v", i, err)
+			return nil, &VideoUploadError{Index: i, URL: videoURL, Err: err}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -0,0 +1,17 @@
+package internal
+
+import "testing"
+
+func TestSniffVideoType(t *testing.T) {
+	mp4 := append([]byte{0, 0, 0, 0x18}, []byte("ftypmp42\x00\x00\x00\x00mp42isom")...)
+	if got, err := sniffVideoType(mp4, ""); err != nil || got != "video/mp4" {
+		t.Fatalf("sniffVideoType(mp4) = (%q, %v)", got, err)
+	}
+	mov := append([]byte{0, 0, 0, 0x14}, []byte("ftypqt  \x00\x00\x00\x00qt  ")...)
+	if got, err := sniffVideoType(mov, ""); err != nil || got != "video/quicktime" {
+		t.Fatalf("sniffVideoType(mov) = (%q, %v)", got, err)
+	}
+	if _, err := sniffVideoType([]byte("\x89PNG\r\n\x1a\n0000"), "video/mp4"); err == nil {
+		t.Fatalf("png content declared as video should be rejected")
+	}
+}
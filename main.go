@@ -10,9 +10,15 @@ func main() {
 	internal.LoadConfig()
 	internal.InitLogger()
 	internal.StartVersionUpdater()
+	internal.StartModelUpdater()
 
 	http.HandleFunc("/v1/models", internal.HandleModels)
+	http.HandleFunc("/v1/models/", internal.HandleModel)
 	http.HandleFunc("/v1/chat/completions", internal.HandleChatCompletions)
+	http.HandleFunc("/v1/images/generations", internal.HandleImageGenerations)
+	http.HandleFunc("/v1/search", internal.HandleSearch)
+	http.HandleFunc("/v1/files", internal.HandleFiles)
+	http.HandleFunc("/v1/files/", internal.HandleFile)
 
 	addr := ":" + internal.Cfg.Port
 	internal.LogInfo("Server starting on %s", addr)